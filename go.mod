@@ -2,4 +2,9 @@ module zivpn
 
 go 1.20
 
-require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+require (
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	go.etcd.io/bbolt v1.3.7
+)
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -158,7 +158,9 @@ run_silent "Downloading API source" \
 "wget -q https://raw.githubusercontent.com/skynet-vpn/xzi/main/zivpn-api.go \
 -O /etc/zivpn/api/zivpn-api.go && \
 wget -q https://raw.githubusercontent.com/skynet-vpn/xzi/main/go.mod \
--O /etc/zivpn/api/go.mod"
+-O /etc/zivpn/api/go.mod && \
+wget -q https://raw.githubusercontent.com/skynet-vpn/xzi/main/go.sum \
+-O /etc/zivpn/api/go.sum"
 
 cd /etc/zivpn/api
 
@@ -193,7 +195,7 @@ read -rp "Bot Token: " bot_token
 read -rp "Admin ID : " admin_id
 
 if [[ -n "$bot_token" && -n "$admin_id" ]]; then
-  echo "{\"bot_token\":\"$bot_token\",\"admin_id\":$admin_id}" > /etc/zivpn/bot-config.json
+  echo "{\"bot_token\":\"$bot_token\",\"admin_id\":$admin_id,\"api_key\":\"$api_key\"}" > /etc/zivpn/bot-config.json
 
   run_silent "Downloading Bot source" \
   "wget -q https://raw.githubusercontent.com/skynet-vpn/xzi/main/zivpn-bot.go \
@@ -1,17 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 const (
@@ -20,58 +37,497 @@ const (
 	DomainFile = "/etc/zivpn/domain"
 	ApiKeyFile = "/etc/zivpn/apikey"
 	Port       = ":8080"
+
+	// StorageFile memilih backend database user: "file" (default, users.db)
+	// atau "bolt" (database embedded di UserBoltDB).
+	StorageFile = "/etc/zivpn/storage"
+	UserBoltDB  = "/etc/zivpn/users.bolt"
+
+	// ApiConfigFile berisi pengaturan opsional API (lihat ApiSettings).
+	ApiConfigFile = "/etc/zivpn/api-config.json"
+	// ViolationLog mencatat setiap pelanggaran limit IP (JSON per baris).
+	ViolationLog = "/etc/zivpn/violations.log"
+
+	// ApiKeysFile menyimpan API key tambahan (hanya hash-nya). Key lama di
+	// ApiKeyFile tetap berlaku sebagai key "legacy" dengan scope admin.
+	ApiKeysFile = "/etc/zivpn/api-keys.json"
+	// ApiAuditLog mencatat setiap request ber-API key (JSON per baris).
+	ApiAuditLog = "/etc/zivpn/api-audit.log"
+	// AuditLogSize adalah jumlah entri audit terakhir yang dipertahankan.
+	// Log dipangkas ke ukuran ini setiap kali mencapai dua kalinya.
+	AuditLogSize = 5000
+
+	// Scope API key, dari yang paling terbatas. Scope yang lebih tinggi
+	// mencakup semua izin scope di bawahnya.
+	ScopeRead      = "read"
+	ScopeUserWrite = "user-write"
+	ScopeAdmin     = "admin"
+	LegacyKeyID    = "legacy"
+
+	// ResellersFile menyimpan akun reseller beserta saldo kreditnya.
+	ResellersFile = "/etc/zivpn/resellers.json"
+
+	// WebhooksFile menyimpan webhook, antrian pengiriman dan dead-letter.
+	WebhooksFile = "/etc/zivpn/webhooks.json"
+	// Pengiriman yang gagal diulang dengan jeda WebhookBaseDelay yang
+	// berlipat dua setiap percobaan (maksimal WebhookMaxDelay). Setelah
+	// WebhookMaxAttempts percobaan pengiriman masuk dead-letter.
+	WebhookMaxAttempts = 8
+	WebhookBaseDelay   = 10 * time.Second
+	WebhookMaxDelay    = 1 * time.Hour
+	WebhookTimeout     = 10 * time.Second
+	WebhookTick        = 5 * time.Second
+	// WebhookHistory adalah jumlah pengiriman selesai yang disimpan.
+	WebhookHistory = 1000
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+
+	// BotConfigFile dibaca hanya untuk memakai grace_period/purge_policy
+	// lama dari bot jika belum diatur di ApiConfigFile.
+	BotConfigFile = "/etc/zivpn/bot-config.json"
+
+	// Kebijakan untuk user expired setelah masa tenggang (GracePeriod).
+	PurgeDelete = "delete"
+	PurgeKeep   = "keep"
+
+	// EventBufferSize adalah jumlah event terakhir yang disimpan di memori
+	// dan di EventLogFile. ID event berlanjut setelah API restart.
+	EventBufferSize = 1000
+	EventLogFile    = "/etc/zivpn/events.log"
+	// EventHeartbeat adalah jeda komentar keep-alive di stream SSE.
+	EventHeartbeat = 25 * time.Second
+
+	// Perubahan yang berdekatan digabung menjadi satu reload/restart core:
+	// eksekusi menunggu RestartDebounce tanpa permintaan baru, paling lama
+	// RestartMaxDelay sejak permintaan pertama.
+	RestartDebounce = 2 * time.Second
+	RestartMaxDelay = 10 * time.Second
+
+	// BulkMaxOperations adalah jumlah operasi maksimal per request bulk.
+	BulkMaxOperations = 1000
+
+	// UserPageMaxLimit adalah jumlah user maksimal per halaman GET /api/users.
+	UserPageMaxLimit = 500
+	// UserPageDefaultLimit dipakai GET /api/v2/users jika limit tidak dikirim.
+	UserPageDefaultLimit = 100
+
+	// HealthCertWarnDays: health check memberi peringatan jika sertifikat
+	// TLS core habis dalam jumlah hari ini.
+	HealthCertWarnDays = 14
+	// HealthzCacheTTL: /healthz (tanpa API key) memakai hasil cek terakhir
+	// selama ini agar tidak menjalankan systemctl dan mengunci database di
+	// setiap hit. /api/health selalu menjalankan cek baru.
+	HealthzCacheTTL = 5 * time.Second
+
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+
+	// Akuntansi trafik per user memakai counter iptables di chain AcctChain.
+	// IP client dipetakan ke user dari auth callback (mode external).
+	AcctChain        = "ZIVPN_ACCT"
+	AcctInterval     = 1 * time.Minute
+	AcctIdleTimeout  = 30 * time.Minute
+	DefaultCorePort  = "5667"
+	bytesPerGigabyte = 1024 * 1024 * 1024
+
+	// Status user di database. User suspended tetap tercatat tetapi
+	// passwordnya dikeluarkan dari daftar password aktif di config.json.
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	// ReasonQuota dipakai untuk suspend otomatis saat kuota habis. Hanya
+	// suspend dengan alasan ini yang diaktifkan kembali secara otomatis.
+	ReasonQuota = "quota"
+	// ReasonLimitIP dipakai saat user di-suspend karena melebihi limit IP.
+	// User aktif kembali otomatis setelah SuspendedUntil lewat.
+	ReasonLimitIP = "limit_ip"
+
+	// ReasonManual adalah alasan default untuk suspend lewat API.
+	ReasonManual = "manual"
+	// ReasonExpired dipakai bot untuk menonaktifkan user expired selama
+	// masa tenggang sebelum dihapus. Renew mengaktifkannya kembali.
+	ReasonExpired = "expired"
+
+	LimitIPActionReject  = "reject"
+	LimitIPActionSuspend = "suspend"
+
+	// Mode auth core zivpn. Pada mode external, core memanggil
+	// AuthCallbackURL untuk setiap koneksi sehingga perubahan user
+	// langsung berlaku tanpa restart.
+	AuthModePasswords = "passwords"
+	AuthModeExternal  = "external"
+	AuthCallbackURL   = "http://127.0.0.1" + Port + "/api/auth"
 )
 
-var AuthToken = "AutoFtBot-agskjgdvsbdreiWG1234512SDKrqw"
+// AuthToken adalah key legacy dari ApiKeyFile. Kosong berarti key legacy
+// tidak aktif dan hanya key di ApiKeysFile yang diterima.
+var AuthToken string
 
 type Config struct {
-	Listen string `json:"listen"`
-	Cert   string `json:"cert"`
-	Key    string `json:"key"`
-	Obfs   string `json:"obfs"`
-	Auth   struct {
-		Mode   string   `json:"mode"`
-		Config []string `json:"config"`
-	} `json:"auth"`
+	Listen string     `json:"listen"`
+	Cert   string     `json:"cert"`
+	Key    string     `json:"key"`
+	Obfs   string     `json:"obfs"`
+	Auth   AuthConfig `json:"auth"`
+}
+
+// AuthConfig adalah blok "auth" di config.json. Config selalu berisi daftar
+// password aktif. Pada mode passwords daftar itu ditulis sebagai "config";
+// pada mode external "config" berisi URL callback dan daftar password
+// disimpan di "passwords" agar bisa kembali ke mode passwords tanpa
+// kehilangan data.
+type AuthConfig struct {
+	Mode   string
+	Config []string
+	HTTP   string
+}
+
+type authConfigJSON struct {
+	Mode      string          `json:"mode"`
+	Config    json.RawMessage `json:"config"`
+	Passwords []string        `json:"passwords,omitempty"`
+}
+
+type externalAuthJSON struct {
+	HTTP string `json:"http"`
+}
+
+func (a AuthConfig) MarshalJSON() ([]byte, error) {
+	out := authConfigJSON{Mode: a.Mode}
+	var err error
+	if a.Mode == AuthModeExternal {
+		out.Config, err = json.Marshal(externalAuthJSON{HTTP: a.HTTP})
+		out.Passwords = a.Config
+		if out.Passwords == nil {
+			out.Passwords = []string{}
+		}
+	} else {
+		passwords := a.Config
+		if passwords == nil {
+			passwords = []string{}
+		}
+		out.Config, err = json.Marshal(passwords)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+func (a *AuthConfig) UnmarshalJSON(data []byte) error {
+	var in authConfigJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	a.Mode = in.Mode
+	a.Config = in.Passwords
+	a.HTTP = ""
+	if len(in.Config) == 0 || string(in.Config) == "null" {
+		return nil
+	}
+	if in.Config[0] == '[' {
+		return json.Unmarshal(in.Config, &a.Config)
+	}
+	var ext externalAuthJSON
+	if err := json.Unmarshal(in.Config, &ext); err != nil {
+		return err
+	}
+	a.HTTP = ext.HTTP
+	return nil
 }
 
+// UserRequest dipakai endpoint user. User dicari berdasarkan Username;
+// Password tetap diterima sebagai pengenal untuk client lama.
 type UserRequest struct {
-	Password string `json:"password"`
-	Days     int    `json:"days"`
-	Duration string `json:"duration"`
+	Username    string  `json:"username"`
+	DisplayName *string `json:"display_name"`
+	Contact     *string `json:"contact"`
+	TelegramID  *int64  `json:"telegram_id"`
+	Password    string  `json:"password"`
+	Days        int     `json:"days"`
+	Duration    string  `json:"duration"`
+	LimitIP     *int    `json:"limit_ip"`
+	LimitQuota  *int    `json:"limit_quota"`
+	Reason      string  `json:"reason"`
+}
+
+type RenameRequest struct {
+	Username    string `json:"username"`
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// UserRecord adalah satu baris di users.db:
+//
+//	password | expired | limit_ip | limit_quota | usage_bytes | status | reason | suspended_until | username | display_name | contact | owner | telegram_id | created_at
+//
+// Baris lama yang hanya berisi "password | expired" tetap terbaca dengan
+// limit 0 (tanpa batas). Username adalah identitas tetap user, sedangkan
+// password hanya kredensial yang boleh diganti.
+type UserRecord struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Contact     string `json:"contact,omitempty"`
+	// TelegramID adalah chat ID Telegram pelanggan untuk pengingat expired.
+	TelegramID int64 `json:"telegram_id,omitempty"`
+
+	Password   string `json:"password"`
+	Expired    string `json:"expired"`
+	LimitIP    int    `json:"limit_ip"`    // jumlah device, 0 = tanpa batas
+	LimitQuota int    `json:"limit_quota"` // dalam GB, 0 = tanpa batas
+	UsageBytes int64  `json:"usage_bytes"` // total trafik upload+download
+	Status     string `json:"status"`      // StatusActive atau StatusSuspended
+	Reason     string `json:"reason"`      // alasan suspend
+
+	// SuspendedUntil (RFC3339) diisi untuk suspend sementara.
+	SuspendedUntil string `json:"suspended_until,omitempty"`
+
+	// Owner adalah ID reseller pembuat user, kosong untuk user milik admin.
+	Owner string `json:"owner,omitempty"`
+
+	// CreatedAt (RFC3339) kosong untuk user yang dibuat sebelum kolom ini ada.
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// ApiSettings dibaca dari ApiConfigFile saat start. Field yang tidak diisi
+// memakai nilai default dari defaultSettings.
+type ApiSettings struct {
+	// LimitIPAction: "reject" menolak koneksi dari IP baru saat limit IP
+	// tercapai, "suspend" men-suspend user selama LimitIPLockout menit.
+	LimitIPAction  string `json:"limit_ip_action"`
+	LimitIPWindow  int    `json:"limit_ip_window_minutes"`
+	LimitIPLockout int    `json:"limit_ip_lockout_minutes"`
+
+	// GracePeriod: lama user expired dinonaktifkan sebelum dihapus, contoh
+	// "3d" atau "12h". "0" berarti langsung dihapus saat expired.
+	GracePeriod string `json:"grace_period"`
+	// PurgePolicy: PurgeDelete atau PurgeKeep (tidak pernah dihapus).
+	PurgePolicy string `json:"purge_policy"`
+
+	// Timezone (nama IANA, contoh "Asia/Jakarta") dipakai untuk menulis
+	// tanggal expired di database dan response API.
+	Timezone string `json:"timezone"`
+}
+
+var defaultSettings = ApiSettings{
+	LimitIPAction:  LimitIPActionReject,
+	LimitIPWindow:  10,
+	LimitIPLockout: 30,
+	GracePeriod:    "3d",
+	PurgePolicy:    PurgeDelete,
+	Timezone:       "Asia/Jakarta",
+}
+
+var settings = defaultSettings
+
+// displayLoc adalah lokasi dari settings.Timezone.
+var displayLoc = time.Local
+
+// UserStore adalah backend penyimpanan data user yang dipakai handler.
+// Save harus atomik: isi lama tetap utuh jika penulisan gagal.
+type UserStore interface {
+	Load() ([]UserRecord, error)
+	Save(users []UserRecord) error
 }
 
 type Response struct {
-	Success bool        `json:"success"`
+	Success bool `json:"success"`
+	// Code adalah kode error yang stabil untuk client; Message boleh berubah.
+	Code    string      `json:"code,omitempty"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Kode error di field "code" respon gagal.
+const (
+	ErrInvalidRequest      = "invalid_request"
+	ErrMethodNotAllowed    = "method_not_allowed"
+	ErrUnauthorized        = "unauthorized"
+	ErrForbidden           = "forbidden"
+	ErrNotFound            = "not_found"
+	ErrUserNotFound        = "user_not_found"
+	ErrUserExists          = "user_exists"
+	ErrUsernameTaken       = "username_taken"
+	ErrPasswordTaken       = "password_taken"
+	ErrAccountLimit        = "account_limit_reached"
+	ErrInsufficientBalance = "insufficient_balance"
+	ErrAlreadySuspended    = "already_suspended"
+	ErrNotSuspended        = "not_suspended"
+	ErrInternal            = "internal_error"
+)
+
 var mutex = &sync.Mutex{}
 
+var store UserStore
+
+// configPath adalah lokasi config.json core. Berupa variabel agar test bisa
+// memakai direktori sementara.
+var configPath = ConfigFile
+
+// CommandRunner menjalankan perintah sistem (iptables, systemctl). Dibuat
+// interface agar logika yang memanggilnya bisa diuji dengan runner palsu.
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+var runner CommandRunner = execRunner{}
+
+var accounting *trafficAccounting
+
+var devices = newDeviceTracker()
+
+var apiKeys *apiKeyStore
+
+var resellers *resellerStore
+
+var webhooks *webhookStore
+
 func main() {
 	if keyBytes, err := ioutil.ReadFile(ApiKeyFile); err == nil {
 		AuthToken = strings.TrimSpace(string(keyBytes))
 	}
+	var err error
+	apiKeys, err = loadApiKeys(ApiKeysFile)
+	if err != nil {
+		log.Fatalf("Gagal membaca API key: %v", err)
+	}
+	if AuthToken == "" && !apiKeys.HasActive() {
+		log.Fatalf("Tidak ada API key aktif: isi %s atau buat key di %s", ApiKeyFile, ApiKeysFile)
+	}
+	resellers, err = loadResellers(ResellersFile)
+	if err != nil {
+		log.Fatalf("Gagal membaca data reseller: %v", err)
+	}
+	events, err = loadEventBus(EventLogFile)
+	if err != nil {
+		log.Fatalf("Gagal membaca log event: %v", err)
+	}
+	webhooks, err = loadWebhooks(WebhooksFile)
+	if err != nil {
+		log.Fatalf("Gagal membaca data webhook: %v", err)
+	}
+	go webhooks.Run()
+	go restarts.Run()
+
+	settings = loadSettings()
+	if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+		displayLoc = loc
+	}
+
+	store, err = openStore()
+	if err != nil {
+		log.Fatalf("Gagal membuka database user: %v", err)
+	}
+	if err := ensureUsernames(); err != nil {
+		log.Fatalf("Gagal menyiapkan username user lama: %v", err)
+	}
+	if err := normalizeExpiries(); err != nil {
+		log.Fatalf("Gagal mengubah format expired user lama: %v", err)
+	}
+
+	accounting = newTrafficAccounting(runner, corePort())
+	if err := accounting.Setup(); err != nil {
+		log.Printf("Akuntansi trafik tidak aktif: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(AcctInterval)
+		for range ticker.C {
+			if err := flushUsage(); err != nil {
+				log.Printf("Gagal menyimpan pemakaian trafik: %v", err)
+			}
+			if err := enforcePolicies(); err != nil {
+				log.Printf("Gagal menjalankan policy user: %v", err)
+			}
+			if _, err := enforceExpiry(false); err != nil {
+				log.Printf("Gagal memproses user expired: %v", err)
+			}
+		}
+	}()
 
-	http.HandleFunc("/api/user/create", authMiddleware(createUser))
-	http.HandleFunc("/api/user/delete", authMiddleware(deleteUser))
-	http.HandleFunc("/api/user/renew", authMiddleware(renewUser))
-	http.HandleFunc("/api/users", authMiddleware(listUsers))
-	http.HandleFunc("/api/info", authMiddleware(getSystemInfo))
+	http.HandleFunc("/api/user/create", authMiddleware(ScopeUserWrite, createUser))
+	http.HandleFunc("/api/user/delete", authMiddleware(ScopeUserWrite, deleteUser))
+	http.HandleFunc("/api/user/renew", authMiddleware(ScopeUserWrite, renewUser))
+	http.HandleFunc("/api/user/rename", authMiddleware(ScopeUserWrite, renameUser))
+	http.HandleFunc("/api/user/update", authMiddleware(ScopeUserWrite, updateUser))
+	http.HandleFunc("/api/user/suspend", authMiddleware(ScopeUserWrite, suspendUser))
+	http.HandleFunc("/api/user/unsuspend", authMiddleware(ScopeUserWrite, unsuspendUser))
+	http.HandleFunc("/api/users", authMiddleware(ScopeRead, listUsers))
+	http.HandleFunc("/api/users/purge-expired", authMiddleware(ScopeAdmin, purgeExpiredUsers))
+	http.HandleFunc("/api/users/bulk", authMiddleware(ScopeUserWrite, bulkUsers))
+	http.HandleFunc("/api/v2/users", authMiddleware(ScopeRead, usersV2))
+	http.HandleFunc("/api/v2/users/", authMiddleware(ScopeRead, userV2))
+	http.HandleFunc("/api/events", authMiddleware(ScopeRead, listEvents))
+	http.HandleFunc("/api/user/usage", authMiddleware(ScopeRead, getUserUsage))
+	http.HandleFunc("/api/violations", authMiddleware(ScopeRead, listViolations))
+	http.HandleFunc("/api/info", authMiddleware(ScopeRead, getSystemInfo))
+	http.HandleFunc("/api/reconcile", authMiddleware(ScopeUserWrite, reconcileUsers))
+	http.HandleFunc("/api/auth", authCallback)
+	http.HandleFunc("/api/auth/mode", authMiddleware(ScopeAdmin, setAuthMode))
+	http.HandleFunc("/api/keys", authMiddleware(ScopeAdmin, listApiKeys))
+	http.HandleFunc("/api/keys/create", authMiddleware(ScopeAdmin, createApiKey))
+	http.HandleFunc("/api/keys/revoke", authMiddleware(ScopeAdmin, revokeApiKey))
+	http.HandleFunc("/api/keys/audit", authMiddleware(ScopeAdmin, listApiAudit))
+	http.HandleFunc("/api/resellers", authMiddleware(ScopeAdmin, listResellers))
+	http.HandleFunc("/api/resellers/create", authMiddleware(ScopeAdmin, createReseller))
+	http.HandleFunc("/api/resellers/update", authMiddleware(ScopeAdmin, updateReseller))
+	http.HandleFunc("/api/resellers/topup", authMiddleware(ScopeAdmin, topupReseller))
+	http.HandleFunc("/api/resellers/delete", authMiddleware(ScopeAdmin, deleteReseller))
+	http.HandleFunc("/api/reseller/me", authMiddleware(ScopeRead, resellerInfo))
+	http.HandleFunc("/api/webhooks", authMiddleware(ScopeAdmin, listWebhooks))
+	http.HandleFunc("/api/webhooks/create", authMiddleware(ScopeAdmin, createWebhook))
+	http.HandleFunc("/api/webhooks/delete", authMiddleware(ScopeAdmin, deleteWebhook))
+	http.HandleFunc("/api/webhooks/redeliver", authMiddleware(ScopeAdmin, redeliverWebhook))
+	http.HandleFunc("/api/webhooks/dead-letters", authMiddleware(ScopeAdmin, listDeadLetters))
+	http.HandleFunc("/api/webhooks/", authMiddleware(ScopeAdmin, webhookRoutes))
+	http.HandleFunc("/metrics", authMiddleware(ScopeRead, serveMetrics))
+	http.HandleFunc("/api/health", authMiddleware(ScopeRead, healthReport))
+	http.HandleFunc("/api/service/status", authMiddleware(ScopeRead, serviceStatus))
+	// Tanpa auth untuk load balancer/monitoring; hanya status per cek
+	http.HandleFunc("/healthz", healthz)
 
 	fmt.Printf("ZiVPN API berjalan di port %s\n", Port)
-	log.Fatal(http.ListenAndServe(Port, nil))
+	log.Fatal(http.ListenAndServe(Port, instrumented(http.DefaultServeMux)))
 }
 
-func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// authMiddleware memeriksa X-API-Key dan scope minimal yang dibutuhkan
+// endpoint. Setiap request yang lolos autentikasi dicatat di ApiAuditLog.
+func authMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("X-API-Key")
-		if token != AuthToken {
-			jsonResponse(w, http.StatusUnauthorized, false, "Unauthorized", nil)
+		// Prometheus hanya bisa mengirim key lewat "Authorization: Bearer"
+		if token == "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		key, ok := apiKeys.Authenticate(token, clientIP(r.RemoteAddr))
+		if !ok {
+			errorResponse(w, http.StatusUnauthorized, ErrUnauthorized, "Unauthorized")
 			return
 		}
-		next(w, r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if !scopeAllows(key.Scope, scope) {
+			errorResponse(rec, http.StatusForbidden, ErrForbidden, "API key tidak punya izin "+scope)
+		} else {
+			next(rec, r.WithContext(context.WithValue(r.Context(), apiKeyContext{}, key)))
+		}
+		if err := recordAudit(AuditEntry{
+			Time:   time.Now().Format(time.RFC3339),
+			KeyID:  key.ID,
+			Name:   key.Name,
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: rec.status,
+			IP:     clientIP(r.RemoteAddr),
+		}); err != nil {
+			log.Printf("Gagal mencatat audit API: %v", err)
+		}
 	}
 }
 
@@ -85,6 +541,17 @@ func jsonResponse(w http.ResponseWriter, status int, success bool, message strin
 	})
 }
 
+// errorResponse menulis respon gagal beserta kode error.
+func errorResponse(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{
+		Success: false,
+		Code:    code,
+		Message: message,
+	})
+}
+
 func createUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
@@ -97,95 +564,79 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Password == "" || (req.Days <= 0 && strings.TrimSpace(req.Duration) == "") {
-		jsonResponse(w, http.StatusBadRequest, false, "Password dan days/duration harus valid", nil)
-		return
-	}
-
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	config, err := loadConfig()
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca config", nil)
+	key := requestKey(r)
+	tx, oerr := beginUserTx(key)
+	if oerr != nil {
+		opResponse(w, oerr)
 		return
 	}
-
-	for _, p := range config.Auth.Config {
-		if p == req.Password {
-			jsonResponse(w, http.StatusConflict, false, "User sudah ada", nil)
-			return
-		}
+	record, cost, oerr := tx.create(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
 	}
-
-	config.Auth.Config = append(config.Auth.Config, req.Password)
-	if err := saveConfig(config); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config", nil)
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal menyimpan user %s: %v", record.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
 		return
 	}
 
-	// Calculate expiry time based on either Duration (preferred) or Days
-	var expiry time.Time
-	durStr := strings.TrimSpace(req.Duration)
-	if durStr != "" {
-		// Support "Nd" for days and standard Go duration strings like "1h"
-		if strings.HasSuffix(durStr, "d") {
-			n, err := strconv.Atoi(strings.TrimSuffix(durStr, "d"))
-			if err != nil {
-				jsonResponse(w, http.StatusBadRequest, false, "Format duration tidak valid", nil)
-				return
-			}
-			expiry = time.Now().Add(time.Duration(n*24) * time.Hour)
-		} else {
-			parsed, err := time.ParseDuration(durStr)
-			if err != nil {
-				jsonResponse(w, http.StatusBadRequest, false, "Format duration tidak valid", nil)
-				return
-			}
-			expiry = time.Now().Add(parsed)
-		}
-	} else {
-		expiry = time.Now().Add(time.Duration(req.Days) * 24 * time.Hour)
+	domain := "Tidak diatur"
+	if domainBytes, err := ioutil.ReadFile(DomainFile); err == nil {
+		domain = strings.TrimSpace(string(domainBytes))
 	}
 
-	// Choose format: if duration provided in hours (not full days) include time component
-	var expDate string
-	if durStr != "" && !strings.HasSuffix(durStr, "d") {
-		expDate = expiry.Format("2006-01-02 15:04:05")
-	} else {
-		expDate = expiry.Format("2006-01-02")
+	data := map[string]interface{}{
+		"username":     record.Username,
+		"display_name": record.DisplayName,
+		"contact":      record.Contact,
+		"password":     record.Password,
+		"expired":      record.Expired,
+		"domain":       domain,
+		"limit_ip":     record.LimitIP,
+		"limit_quota":  record.LimitQuota,
 	}
-	entry := fmt.Sprintf("%s | %s\n", req.Password, expDate)
+	addResellerBalance(data, key.Reseller, cost)
+	jsonResponse(w, http.StatusOK, true, "User berhasil dibuat", data)
+}
 
-	f, err := os.OpenFile(UserDB, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membuka database user", nil)
+func deleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
 		return
 	}
-	defer f.Close()
-	if _, err := f.WriteString(entry); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menulis database user", nil)
+
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
 		return
 	}
 
-	if err := restartService(); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal merestart service", nil)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tx, oerr := beginUserTx(requestKey(r))
+	if oerr != nil {
+		opResponse(w, oerr)
 		return
 	}
-
-	domain := "Tidak diatur"
-	if domainBytes, err := ioutil.ReadFile(DomainFile); err == nil {
-		domain = strings.TrimSpace(string(domainBytes))
+	deleted, oerr := tx.delete(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
 	}
-
-	jsonResponse(w, http.StatusOK, true, "User berhasil dibuat", map[string]string{
-		"password": req.Password,
-		"expired":  expDate,
-		"domain":   domain,
-	})
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal menghapus user %s: %v", deleted.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "User berhasil dihapus", nil)
 }
 
-func deleteUser(w http.ResponseWriter, r *http.Request) {
+func renewUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
 		return
@@ -200,62 +651,81 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	config, err := loadConfig()
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca config", nil)
+	key := requestKey(r)
+	tx, oerr := beginUserTx(key)
+	if oerr != nil {
+		opResponse(w, oerr)
 		return
 	}
-
-	found := false
-	newConfigAuth := []string{}
-	for _, p := range config.Auth.Config {
-		if p == req.Password {
-			found = true
-		} else {
-			newConfigAuth = append(newConfigAuth, p)
-		}
+	renewed, cost, oerr := tx.renew(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
 	}
-
-	if !found {
-		jsonResponse(w, http.StatusNotFound, false, "User tidak ditemukan", nil)
+	// Pada mode passwords restart tetap dilakukan untuk memastikan konsistensi
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal memperpanjang user %s: %v", renewed.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
 		return
 	}
 
-	config.Auth.Config = newConfigAuth
-	if err := saveConfig(config); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config", nil)
-		return
+	data := map[string]interface{}{
+		"username":    renewed.Username,
+		"password":    renewed.Password,
+		"expired":     renewed.Expired,
+		"limit_ip":    renewed.LimitIP,
+		"limit_quota": renewed.LimitQuota,
+		"status":      renewed.Status,
 	}
+	addResellerBalance(data, key.Reseller, cost)
+	jsonResponse(w, http.StatusOK, true, "User berhasil diperpanjang", data)
+}
 
-	users, err := loadUsers()
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+// renameUser adalah endpoint v1 untuk userTx.rename.
+func renameUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
 		return
 	}
 
-	newUsers := []string{}
-	for _, line := range users {
-		parts := strings.Split(line, "|")
-		if len(parts) > 0 && strings.TrimSpace(parts[0]) == req.Password {
-			continue
-		}
-		newUsers = append(newUsers, line)
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
 	}
 
-	if err := saveUsers(newUsers); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan database user", nil)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tx, oerr := beginUserTx(requestKey(r))
+	if oerr != nil {
+		opResponse(w, oerr)
 		return
 	}
-
-	if err := restartService(); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal merestart service", nil)
+	u, oerr := tx.rename(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal mengganti password user %s: %v", u.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, true, "User berhasil dihapus", nil)
+	jsonResponse(w, http.StatusOK, true, "Password berhasil diganti", map[string]interface{}{
+		"username":    u.Username,
+		"password":    u.Password,
+		"expired":     u.Expired,
+		"limit_ip":    u.LimitIP,
+		"limit_quota": u.LimitQuota,
+		"status":      u.Status,
+	})
 }
 
-func renewUser(w http.ResponseWriter, r *http.Request) {
+// updateUser mengubah profil user (display_name, contact, telegram_id)
+// tanpa menyentuh expired, limit maupun pemakaian.
+func updateUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
 		return
@@ -266,202 +736,4944 @@ func renewUser(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
 		return
 	}
+	// Limit di v1 hanya berubah lewat create/renew
+	req.LimitIP, req.LimitQuota = nil, nil
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	users, err := loadUsers()
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+	tx, oerr := beginUserTx(requestKey(r))
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+	u, oerr := tx.update(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal memperbarui user %s: %v", u.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan database user", nil)
 		return
 	}
 
-	found := false
-	newUsers := []string{}
-	var newExpDate string
-
-	for _, line := range users {
-		parts := strings.Split(line, "|")
-		if len(parts) >= 2 && strings.TrimSpace(parts[0]) == req.Password {
-			found = true
-			currentExpStr := strings.TrimSpace(parts[1])
-			currentExp, err := time.Parse("2006-01-02", currentExpStr)
-			if err != nil {
-				// Jika format tanggal salah, anggap hari ini
-				currentExp = time.Now()
-			}
-
-			// Jika sudah expired, mulai dari hari ini. Jika belum, tambah dari tanggal expired.
-			if currentExp.Before(time.Now()) {
-				currentExp = time.Now()
-			}
-
-			// determine extension duration: prefer Duration if provided
-			durStr := strings.TrimSpace(req.Duration)
-			var addDur time.Duration
-			if durStr != "" {
-				if strings.HasSuffix(durStr, "d") {
-					n, err := strconv.Atoi(strings.TrimSuffix(durStr, "d"))
-					if err != nil {
-						jsonResponse(w, http.StatusBadRequest, false, "Format duration tidak valid", nil)
-						return
-					}
-					addDur = time.Duration(n*24) * time.Hour
-				} else {
-					parsed, err := time.ParseDuration(durStr)
-					if err != nil {
-						jsonResponse(w, http.StatusBadRequest, false, "Format duration tidak valid", nil)
-						return
-					}
-					addDur = parsed
-				}
-			} else {
-				addDur = time.Duration(req.Days) * 24 * time.Hour
-			}
+	jsonResponse(w, http.StatusOK, true, "Profil user diperbarui", map[string]interface{}{
+		"username":     u.Username,
+		"display_name": u.DisplayName,
+		"contact":      u.Contact,
+		"telegram_id":  u.TelegramID,
+	})
+}
 
-			newExp := currentExp.Add(addDur)
-			// if Duration was provided as hours, store full timestamp, otherwise store date only
-			if durStr != "" && !strings.HasSuffix(durStr, "d") {
-				newExpDate = newExp.Format("2006-01-02 15:04:05")
-			} else {
-				newExpDate = newExp.Format("2006-01-02")
-			}
-			newUsers = append(newUsers, fmt.Sprintf("%s | %s", req.Password, newExpDate))
-		} else {
-			newUsers = append(newUsers, line)
-		}
+func suspendUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
 	}
 
-	if !found {
-		jsonResponse(w, http.StatusNotFound, false, "User tidak ditemukan di database", nil)
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
 		return
 	}
 
-	if err := saveUsers(newUsers); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan database user", nil)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tx, oerr := beginUserTx(requestKey(r))
+	if oerr != nil {
+		opResponse(w, oerr)
 		return
 	}
-
-	// Restart service mungkin tidak diperlukan untuk renew, tapi bagus untuk memastikan konsistensi
-	if err := restartService(); err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal merestart service", nil)
+	u, oerr := tx.suspend(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal men-suspend user %s: %v", u.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, true, "User berhasil diperpanjang", map[string]string{
-		"password": req.Password,
-		"expired":  newExpDate,
+	jsonResponse(w, http.StatusOK, true, "User berhasil di-suspend", map[string]interface{}{
+		"username": u.Username,
+		"status":   u.Status,
+		"reason":   u.Reason,
 	})
 }
 
-func listUsers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func unsuspendUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
 		return
 	}
 
-	users, err := loadUsers()
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
 		return
 	}
 
-	type UserInfo struct {
-		Password string `json:"password"`
-		Expired  string `json:"expired"`
-		Status   string `json:"status"`
-	}
-
-	userList := []UserInfo{}
-	today := time.Now().Format("2006-01-02")
+	mutex.Lock()
+	defer mutex.Unlock()
 
-	for _, line := range users {
-		parts := strings.Split(line, "|")
-		if len(parts) >= 2 {
-			pass := strings.TrimSpace(parts[0])
-			exp := strings.TrimSpace(parts[1])
-			status := "Active"
-			if exp < today {
-				status = "Expired"
-			}
-			userList = append(userList, UserInfo{
-				Password: pass,
-				Expired:  exp,
-				Status:   status,
-			})
-		}
+	tx, oerr := beginUserTx(requestKey(r))
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+	u, oerr := tx.unsuspend(req)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+	if err := tx.commit(); err != nil {
+		log.Printf("Gagal meng-unsuspend user %s: %v", u.Username, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
+		return
 	}
 
-	jsonResponse(w, http.StatusOK, true, "Daftar user", userList)
+	jsonResponse(w, http.StatusOK, true, "User berhasil diaktifkan kembali", map[string]interface{}{
+		"username": u.Username,
+		"status":   u.Status,
+	})
 }
 
-func getSystemInfo(w http.ResponseWriter, r *http.Request) {
-	cmd := exec.Command("curl", "-s", "ifconfig.me")
-	ipPub, _ := cmd.Output()
+type UserInfo struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Contact     string `json:"contact,omitempty"`
+	TelegramID  int64  `json:"telegram_id,omitempty"`
+	Password    string `json:"password"`
+	Expired     string `json:"expired"`
+	Status      string `json:"status"`
+	LimitIP     int    `json:"limit_ip"`
+	LimitQuota  int    `json:"limit_quota"`
+	UsageBytes  int64  `json:"usage_bytes"`
+	Reason      string `json:"reason,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	CreatedAt   string `json:"created_at,omitempty"`
 
-	cmd = exec.Command("hostname", "-I")
-	ipPriv, _ := cmd.Output()
+	sortKey string
+}
 
-	domain := "Tidak diatur"
-	if domainBytes, err := ioutil.ReadFile(DomainFile); err == nil {
-		domain = strings.TrimSpace(string(domainBytes))
-	}
+// UserPage adalah respon GET /api/users jika limit atau cursor dikirim.
+// NextCursor kosong berarti sudah halaman terakhir.
+type UserPage struct {
+	Users      []UserInfo `json:"users"`
+	Total      int        `json:"total"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
 
-	info := map[string]string{
+// userQuery adalah filter GET /api/users:
+//
+//	status=active,expired,suspended  expiring_within=3d  q=teks  owner=<id>|admin
+//	sort=expiry|created|username (awali "-" untuk urutan menurun)  limit=N  cursor=...
+type userQuery struct {
+	Status map[string]bool
+	Within time.Duration
+	Search string
+	Owner  string
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor string
+	Paged  bool
+}
+
+func parseUserQuery(v url.Values) (userQuery, error) {
+	q := userQuery{Sort: "created"}
+	if raw := strings.TrimSpace(v.Get("status")); raw != "" {
+		q.Status = map[string]bool{}
+		for _, st := range strings.Split(strings.ToLower(raw), ",") {
+			st = strings.TrimSpace(st)
+			if st != "active" && st != "expired" && st != "suspended" {
+				return q, fmt.Errorf("Status harus active, expired atau suspended")
+			}
+			q.Status[st] = true
+		}
+	}
+	if raw := strings.TrimSpace(v.Get("expiring_within")); raw != "" {
+		d, err := parseSpan(raw)
+		if err != nil || d <= 0 {
+			return q, fmt.Errorf("Format expiring_within tidak valid")
+		}
+		q.Within = d
+	}
+	q.Search = strings.ToLower(strings.TrimSpace(v.Get("q")))
+	q.Owner = strings.TrimSpace(v.Get("owner"))
+	if raw := strings.TrimSpace(v.Get("sort")); raw != "" {
+		q.Desc = strings.HasPrefix(raw, "-")
+		q.Sort = strings.TrimPrefix(raw, "-")
+		if q.Sort != "expiry" && q.Sort != "created" && q.Sort != "username" {
+			return q, fmt.Errorf("Sort harus expiry, created atau username")
+		}
+	}
+	if raw := strings.TrimSpace(v.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("Limit harus angka positif")
+		}
+		if n > UserPageMaxLimit {
+			n = UserPageMaxLimit
+		}
+		q.Limit = n
+		q.Paged = true
+	}
+	if raw := strings.TrimSpace(v.Get("cursor")); raw != "" {
+		c, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil || !strings.Contains(string(c), "\x00") {
+			return q, fmt.Errorf("Cursor tidak valid")
+		}
+		q.Cursor = string(c)
+		q.Paged = true
+	}
+	if q.Paged && q.Limit == 0 {
+		q.Limit = UserPageMaxLimit
+	}
+	return q, nil
+}
+
+// match memeriksa filter selain sort dan pagination.
+func (q userQuery) match(u UserRecord, info UserInfo, now time.Time) bool {
+	if q.Status != nil && !q.Status[strings.ToLower(info.Status)] {
+		return false
+	}
+	if q.Within > 0 {
+		exp, err := parseExpiry(u.Expired)
+		if err != nil || info.Status != "Active" || exp.After(now.Add(q.Within)) {
+			return false
+		}
+	}
+	if q.Owner != "" {
+		owner := u.Owner
+		if owner == "" {
+			owner = "admin"
+		}
+		if owner != q.Owner {
+			return false
+		}
+	}
+	if q.Search != "" {
+		hit := false
+		for _, f := range []string{u.Username, u.Password, u.DisplayName, u.Contact} {
+			if strings.Contains(strings.ToLower(f), q.Search) {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKey menghasilkan kunci urut "nilai\x00username". Waktu ditulis dalam
+// UTC dengan lebar tetap agar urutan string sama dengan urutan waktu; nilai
+// kosong (data lama tanpa created_at) berada paling awal.
+func (q userQuery) sortKey(u UserRecord) string {
+	value := u.Username
+	switch q.Sort {
+	case "expiry", "created":
+		raw := u.Expired
+		if q.Sort == "created" {
+			raw = u.CreatedAt
+		}
+		value = ""
+		if t, err := parseExpiry(raw); err == nil && raw != "" {
+			value = t.UTC().Format("20060102150405")
+		}
+	}
+	return value + "\x00" + u.Username
+}
+
+// userStatus mengembalikan status tampilan user: "Active", "Expired" atau
+// "Suspended". User yang dinonaktifkan enforceExpiry (suspend dengan alasan
+// ReasonExpired) tetap dihitung expired, bukan suspended.
+func userStatus(u UserRecord, now time.Time) string {
+	switch {
+	case u.Reason == ReasonExpired || isExpired(u, now):
+		return "Expired"
+	case u.Status == StatusSuspended:
+		return "Suspended"
+	}
+	return "Active"
+}
+
+// userInfo adalah bentuk user di respon API.
+func userInfo(u UserRecord, now time.Time) UserInfo {
+	status := userStatus(u, now)
+	return UserInfo{
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Contact:     u.Contact,
+		TelegramID:  u.TelegramID,
+		Password:    u.Password,
+		Expired:     displayExpiry(u.Expired),
+		Status:      status,
+		LimitIP:     u.LimitIP,
+		LimitQuota:  u.LimitQuota,
+		UsageBytes:  u.UsageBytes + accounting.Pending(u.Username),
+		Reason:      u.Reason,
+		Owner:       u.Owner,
+		CreatedAt:   displayExpiry(u.CreatedAt),
+	}
+}
+
+// queryUsers mengembalikan user yang terlihat oleh key dan cocok dengan
+// filter, sudah terurut.
+func queryUsers(key ApiKey, query userQuery) ([]UserInfo, error) {
+	mutex.Lock()
+	users, err := store.Load()
+	mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	userList := []UserInfo{}
+	now := time.Now()
+	for _, u := range users {
+		// Reseller hanya melihat user miliknya sendiri
+		if !ownsUser(key, u) {
+			continue
+		}
+		info := userInfo(u, now)
+		info.sortKey = query.sortKey(u)
+		if query.match(u, info, now) {
+			userList = append(userList, info)
+		}
+	}
+
+	sort.SliceStable(userList, func(i, j int) bool {
+		if query.Desc {
+			return userList[i].sortKey > userList[j].sortKey
+		}
+		return userList[i].sortKey < userList[j].sortKey
+	})
+	return userList, nil
+}
+
+// pageUsers memotong hasil queryUsers sesuai limit dan cursor. Cursor
+// adalah kunci urut user terakhir di halaman sebelumnya, sehingga user yang
+// dibuat atau dihapus di antara dua request tidak menggeser halaman.
+func pageUsers(userList []UserInfo, query userQuery) UserPage {
+	page := UserPage{Total: len(userList)}
+	start := 0
+	if query.Cursor != "" {
+		start = sort.Search(len(userList), func(i int) bool {
+			if query.Desc {
+				return userList[i].sortKey < query.Cursor
+			}
+			return userList[i].sortKey > query.Cursor
+		})
+	}
+	end := start + query.Limit
+	if end > len(userList) {
+		end = len(userList)
+	}
+	page.Users = userList[start:end]
+	if end < len(userList) {
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(userList[end-1].sortKey))
+	}
+	return page
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	query, err := parseUserQuery(r.URL.Query())
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	userList, err := queryUsers(requestKey(r), query)
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+		return
+	}
+
+	if !query.Paged {
+		jsonResponse(w, http.StatusOK, true, "Daftar user", userList)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Daftar user", pageUsers(userList, query))
+}
+
+// ReconcileRequest mengatur perbaikan selisih antara config.json dan
+// database user. Aksi kosong berarti sisi tersebut tidak diubah.
+type ReconcileRequest struct {
+	DryRun bool `json:"dry_run"`
+	// ConfigOnly: password di config.json tanpa record di database user.
+	// "import" membuat record dengan masa aktif Days/Duration (wajib),
+	// "remove" menghapusnya dari config.
+	ConfigOnly string `json:"config_only"`
+	Days       int    `json:"days"`
+	Duration   string `json:"duration"`
+	// DbOnly: record di database user yang passwordnya tidak ada di config.json.
+	// "activate" menambahkannya ke config, "remove" menghapus record.
+	DbOnly string `json:"db_only"`
+}
+
+func reconcileUsers(w http.ResponseWriter, r *http.Request) {
+	if requestKey(r).Reseller != "" {
+		jsonResponse(w, http.StatusForbidden, false, "Reconcile hanya untuk admin", nil)
+		return
+	}
+
+	req := ReconcileRequest{DryRun: true}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+	default:
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	if req.ConfigOnly != "" && req.ConfigOnly != "import" && req.ConfigOnly != "remove" {
+		jsonResponse(w, http.StatusBadRequest, false, "config_only harus import atau remove", nil)
+		return
+	}
+	if req.DbOnly != "" && req.DbOnly != "activate" && req.DbOnly != "remove" {
+		jsonResponse(w, http.StatusBadRequest, false, "db_only harus activate atau remove", nil)
+		return
+	}
+	// Record tanpa expired tidak pernah kedaluwarsa, jadi import wajib
+	// menyertakan masa aktif
+	var importDur time.Duration
+	if req.ConfigOnly == "import" {
+		dur, oerr := requestDuration(UserRequest{Days: req.Days, Duration: req.Duration})
+		if oerr != nil {
+			jsonResponse(w, http.StatusBadRequest, false, "Import membutuhkan days/duration lebih dari 0", nil)
+			return
+		}
+		importDur = dur
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	config, err := loadConfig()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca config", nil)
+		return
+	}
+	users, err := store.Load()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+		return
+	}
+
+	inConfig := make(map[string]bool)
+	for _, p := range config.Auth.Config {
+		inConfig[p] = true
+	}
+	inDb := make(map[string]bool)
+	for _, u := range users {
+		inDb[u.Password] = true
+	}
+
+	configOnly := []string{}
+	for _, p := range config.Auth.Config {
+		if !inDb[p] {
+			configOnly = append(configOnly, p)
+		}
+	}
+	dbOnly := []string{}
+	for _, u := range users {
+		// User suspended memang sengaja tidak ada di config
+		if !inConfig[u.Password] && u.Status != StatusSuspended {
+			dbOnly = append(dbOnly, u.Password)
+		}
+	}
+
+	result := map[string]interface{}{
+		"config_only": configOnly,
+		"db_only":     dbOnly,
+		"dry_run":     req.DryRun,
+		"applied":     false,
+	}
+
+	changed := (len(configOnly) > 0 && req.ConfigOnly != "") || (len(dbOnly) > 0 && req.DbOnly != "")
+	if req.DryRun || !changed {
+		jsonResponse(w, http.StatusOK, true, "Hasil rekonsiliasi", result)
+		return
+	}
+
+	switch req.ConfigOnly {
+	case "import":
+		now := time.Now()
+		for _, p := range configOnly {
+			users = append(users, UserRecord{Username: generateUsername(users), Password: p, Expired: formatExpiry(now.Add(importDur)), Status: StatusActive, CreatedAt: formatExpiry(now)})
+		}
+	case "remove":
+		kept := []string{}
+		for _, p := range config.Auth.Config {
+			if inDb[p] {
+				kept = append(kept, p)
+			}
+		}
+		config.Auth.Config = kept
+	}
+
+	switch req.DbOnly {
+	case "activate":
+		config.Auth.Config = append(config.Auth.Config, dbOnly...)
+	case "remove":
+		kept := []UserRecord{}
+		for _, u := range users {
+			if inConfig[u.Password] || u.Status == StatusSuspended {
+				kept = append(kept, u)
+			}
+		}
+		users = kept
+	}
+
+	if err := saveConfigAndUsers(config, users); err != nil {
+		log.Printf("Gagal menerapkan rekonsiliasi: %v", err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
+		return
+	}
+	applyUserChange()
+
+	result["applied"] = true
+	jsonResponse(w, http.StatusOK, true, "Rekonsiliasi diterapkan", result)
+}
+
+// authRequest adalah body yang dikirim core zivpn pada mode external.
+// Payload berisi password yang dimasukkan client (base64 di JSON).
+type authRequest struct {
+	Addr    string `json:"addr"`
+	Payload []byte `json:"payload"`
+	Send    uint64 `json:"send"`
+	Recv    uint64 `json:"recv"`
+}
+
+// authCallback dipanggil core zivpn untuk setiap koneksi baru. Status 200
+// berarti diterima, status lain ditolak dan body dikirim sebagai alasan.
+// Endpoint ini tidak memakai API key sehingga hanya menerima request dari
+// localhost.
+func authCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isLoopback(r.RemoteAddr) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req authRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	username, ok, msg := authorizeUser(string(req.Payload), req.Addr)
+	if !ok {
+		log.Printf("Auth ditolak untuk %s: %s", req.Addr, msg)
+		http.Error(w, msg, http.StatusUnauthorized)
+		return
+	}
+	if err := accounting.Track(username, req.Addr); err != nil {
+		log.Printf("Gagal memasang counter trafik untuk %s: %v", req.Addr, err)
+	}
+	fmt.Fprint(w, msg)
+}
+
+// authorizeUser memutuskan apakah password boleh terhubung: harus ada di
+// daftar password aktif, tercatat di users.db dan belum expired. Username
+// pemilik password dikembalikan jika diterima.
+func authorizeUser(password, addr string) (string, bool, string) {
+	if password == "" {
+		return "", false, "password kosong"
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	config, err := loadConfig()
+	if err != nil {
+		return "", false, "gagal membaca config"
+	}
+	active := false
+	for _, p := range config.Auth.Config {
+		if p == password {
+			active = true
+			break
+		}
+	}
+	if !active {
+		return "", false, "user tidak aktif"
+	}
+
+	users, err := store.Load()
+	if err != nil {
+		return "", false, "gagal membaca database user"
+	}
+	for _, u := range users {
+		if u.Password != password {
+			continue
+		}
+		if u.Status == StatusSuspended {
+			return "", false, "user suspended"
+		}
+		if isExpired(u, time.Now()) {
+			return "", false, "user expired"
+		}
+		if ok, msg := checkDeviceLimit(config, users, u, addr); !ok {
+			return "", false, msg
+		}
+		return u.Username, true, "ok"
+	}
+	return "", false, "user tidak ditemukan"
+}
+
+// checkDeviceLimit menerapkan limit IP saat auth. Harus dipanggil dengan
+// mutex terkunci karena aksi suspend menulis config dan database user.
+func checkDeviceLimit(config Config, users []UserRecord, u UserRecord, addr string) (bool, string) {
+	ip := clientIP(addr)
+	if u.LimitIP <= 0 || ip == "" {
+		return true, ""
+	}
+
+	window := time.Duration(settings.LimitIPWindow) * time.Minute
+	active, ok := devices.Admit(u.Username, ip, u.LimitIP, window, time.Now())
+	if ok {
+		return true, ""
+	}
+
+	v := Violation{
+		Time:      time.Now().Format(time.RFC3339),
+		Username:  u.Username,
+		IP:        ip,
+		ActiveIPs: active,
+		LimitIP:   u.LimitIP,
+		Action:    settings.LimitIPAction,
+	}
+	if err := recordViolation(v); err != nil {
+		log.Printf("Gagal mencatat pelanggaran limit IP: %v", err)
+	}
+	log.Printf("User %s melebihi limit IP (%d): %s ditolak, aktif: %v", u.Username, u.LimitIP, ip, active)
+
+	if settings.LimitIPAction != LimitIPActionSuspend {
+		return false, "limit IP tercapai"
+	}
+
+	until := time.Now().Add(time.Duration(settings.LimitIPLockout) * time.Minute)
+	if i := findUsername(users, u.Username); i >= 0 {
+		users[i].Status = StatusSuspended
+		users[i].Reason = ReasonLimitIP
+		users[i].SuspendedUntil = until.Format(time.RFC3339)
+	}
+	config.Auth.Config = removePassword(config.Auth.Config, u.Password)
+	if err := saveConfigAndUsers(config, users); err != nil {
+		log.Printf("Gagal men-suspend user %s: %v", u.Username, err)
+	} else {
+		// Restart agar sesi yang sudah berjalan ikut terputus; tanpa itu
+		// device yang melebihi limit tetap terhubung selama lock-out.
+		restarts.Request(true)
+		events.Publish(Event{Type: EventUserSuspended, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"reason": ReasonLimitIP, "until": until.Format(time.RFC3339)}})
+	}
+	return false, "limit IP tercapai, user di-suspend sampai " + until.In(displayLoc).Format("2006-01-02 15:04:05")
+}
+
+func setAuthMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	if req.Mode != AuthModePasswords && req.Mode != AuthModeExternal {
+		jsonResponse(w, http.StatusBadRequest, false, "Mode harus passwords atau external", nil)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	config, err := loadConfig()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca config", nil)
+		return
+	}
+
+	if config.Auth.Mode != req.Mode {
+		config.Auth.Mode = req.Mode
+		config.Auth.HTTP = ""
+		if req.Mode == AuthModeExternal {
+			config.Auth.HTTP = AuthCallbackURL
+		}
+		if err := saveConfig(config); err != nil {
+			jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config", nil)
+			return
+		}
+		// Pergantian mode selalu butuh restart agar core membaca blok auth baru
+		restarts.Request(true)
+	}
+
+	jsonResponse(w, http.StatusOK, true, "Mode auth diperbarui", map[string]string{
+		"mode": config.Auth.Mode,
+		"http": config.Auth.HTTP,
+	})
+}
+
+func getUserUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	password := r.URL.Query().Get("password")
+	if username == "" && password == "" {
+		jsonResponse(w, http.StatusBadRequest, false, "Parameter username atau password wajib diisi", nil)
+		return
+	}
+
+	mutex.Lock()
+	users, err := store.Load()
+	mutex.Unlock()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+		return
+	}
+
+	idx := lookupOwnedUser(users, requestKey(r), username, password)
+	if idx < 0 {
+		jsonResponse(w, http.StatusNotFound, false, "User tidak ditemukan", nil)
+		return
+	}
+	u := users[idx]
+	usage := u.UsageBytes + accounting.Pending(u.Username)
+	jsonResponse(w, http.StatusOK, true, "Pemakaian user", map[string]interface{}{
+		"username":    u.Username,
+		"usage_bytes": usage,
+		"usage_gb":    float64(usage) / bytesPerGigabyte,
+		"limit_quota": u.LimitQuota,
+		"client_ips":  accounting.IPs(u.Username),
+	})
+}
+
+func listViolations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	username := r.URL.Query().Get("username")
+
+	violations, err := loadViolations()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca log pelanggaran", nil)
+		return
+	}
+
+	// Reseller hanya melihat pelanggaran user miliknya
+	var owned map[string]bool
+	if key := requestKey(r); key.Reseller != "" {
+		mutex.Lock()
+		users, err := store.Load()
+		mutex.Unlock()
+		if err != nil {
+			jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+			return
+		}
+		owned = make(map[string]bool)
+		for _, u := range users {
+			if ownsUser(key, u) {
+				owned[u.Username] = true
+			}
+		}
+	}
+
+	// Terbaru lebih dulu
+	result := []Violation{}
+	for i := len(violations) - 1; i >= 0 && len(result) < limit; i-- {
+		if username != "" && violations[i].Username != username {
+			continue
+		}
+		if owned != nil && !owned[violations[i].Username] {
+			continue
+		}
+		result = append(result, violations[i])
+	}
+	jsonResponse(w, http.StatusOK, true, "Daftar pelanggaran limit IP", result)
+}
+
+func getSystemInfo(w http.ResponseWriter, r *http.Request) {
+	cmd := exec.Command("curl", "-s", "ifconfig.me")
+	ipPub, _ := cmd.Output()
+
+	cmd = exec.Command("hostname", "-I")
+	ipPriv, _ := cmd.Output()
+	privateIP := ""
+	if fields := strings.Fields(string(ipPriv)); len(fields) > 0 {
+		privateIP = fields[0]
+	}
+
+	domain := "Tidak diatur"
+	if domainBytes, err := ioutil.ReadFile(DomainFile); err == nil {
+		domain = strings.TrimSpace(string(domainBytes))
+	}
+
+	info := map[string]string{
 		"domain":     domain,
 		"public_ip":  strings.TrimSpace(string(ipPub)),
-		"private_ip": strings.Fields(string(ipPriv))[0],
-		"port":       "5667",
+		"private_ip": privateIP,
+		"port":       corePort(),
 		"service":    "zivpn",
+		"timezone":   displayLoc.String(),
+	}
+
+	jsonResponse(w, http.StatusOK, true, "System Info", info)
+}
+
+// --- Helper Functions ---
+
+func loadConfig() (Config, error) {
+	var config Config
+	file, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(file, &config)
+	return config, err
+}
+
+func loadSettings() ApiSettings {
+	st := defaultSettings
+	// Pengaturan masa tenggang dulu ada di config bot; tetap dipakai sebagai
+	// nilai awal sampai diatur di ApiConfigFile.
+	if data, err := ioutil.ReadFile(BotConfigFile); err == nil {
+		var legacy struct {
+			GracePeriod string `json:"grace_period"`
+			PurgePolicy string `json:"purge_policy"`
+		}
+		if json.Unmarshal(data, &legacy) == nil {
+			if legacy.GracePeriod != "" {
+				st.GracePeriod = legacy.GracePeriod
+			}
+			if legacy.PurgePolicy != "" {
+				st.PurgePolicy = legacy.PurgePolicy
+			}
+		}
+	}
+
+	data, err := ioutil.ReadFile(ApiConfigFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Gagal membaca %s: %v", ApiConfigFile, err)
+		}
+		return st
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("Format %s tidak valid, memakai pengaturan default: %v", ApiConfigFile, err)
+		return defaultSettings
+	}
+	if st.LimitIPAction != LimitIPActionReject && st.LimitIPAction != LimitIPActionSuspend {
+		st.LimitIPAction = defaultSettings.LimitIPAction
+	}
+	if st.LimitIPWindow <= 0 {
+		st.LimitIPWindow = defaultSettings.LimitIPWindow
+	}
+	if st.LimitIPLockout <= 0 {
+		st.LimitIPLockout = defaultSettings.LimitIPLockout
+	}
+	if d, err := parseSpan(st.GracePeriod); err != nil || d < 0 {
+		log.Printf("grace_period %q tidak valid, memakai %s", st.GracePeriod, defaultSettings.GracePeriod)
+		st.GracePeriod = defaultSettings.GracePeriod
+	}
+	if st.PurgePolicy != PurgeDelete && st.PurgePolicy != PurgeKeep {
+		st.PurgePolicy = defaultSettings.PurgePolicy
+	}
+	if _, err := time.LoadLocation(st.Timezone); err != nil || st.Timezone == "" {
+		log.Printf("timezone %q tidak valid, memakai %s", st.Timezone, defaultSettings.Timezone)
+		st.Timezone = defaultSettings.Timezone
+	}
+	return st
+}
+
+// parseSpan membaca durasi seperti "3d" (hari) atau format durasi Go ("12h").
+func parseSpan(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func saveConfig(config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(configPath, data, 0644)
+}
+
+// saveConfigAndUsers menyimpan config.json dan database user sebagai satu
+// transaksi. Jika database user gagal ditulis, config.json dikembalikan ke
+// isi sebelumnya sehingga daftar password dan data expired tidak selisih.
+func saveConfigAndUsers(config Config, users []UserRecord) error {
+	prev, err := ioutil.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := saveConfig(config); err != nil {
+		return err
+	}
+	if err := store.Save(users); err != nil {
+		if prev != nil {
+			if rbErr := writeFileAtomic(configPath, prev, 0644); rbErr != nil {
+				log.Printf("Rollback %s gagal: %v", configPath, rbErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic menulis ke file sementara di direktori yang sama, fsync,
+// lalu rename ke path tujuan. Pembaca tidak pernah melihat file setengah
+// tertulis dan isi lama tetap utuh jika proses mati di tengah jalan.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// fsync direktori agar rename ikut tersimpan di disk
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// parseUserLine membaca satu baris users.db. Kolom limit bersifat opsional
+// agar baris format lama (password | expired) tetap valid.
+func parseUserLine(line string) (UserRecord, bool) {
+	parts := strings.Split(line, "|")
+	if len(parts) < 2 {
+		return UserRecord{}, false
+	}
+	u := UserRecord{
+		Password: strings.TrimSpace(parts[0]),
+		Expired:  strings.TrimSpace(parts[1]),
+	}
+	if u.Password == "" {
+		return UserRecord{}, false
+	}
+	if len(parts) >= 3 {
+		u.LimitIP, _ = strconv.Atoi(strings.TrimSpace(parts[2]))
+	}
+	if len(parts) >= 4 {
+		u.LimitQuota, _ = strconv.Atoi(strings.TrimSpace(parts[3]))
+	}
+	if len(parts) >= 5 {
+		u.UsageBytes, _ = strconv.ParseInt(strings.TrimSpace(parts[4]), 10, 64)
+	}
+	if len(parts) >= 6 {
+		u.Status = strings.TrimSpace(parts[5])
+	}
+	if len(parts) >= 7 {
+		u.Reason = strings.TrimSpace(parts[6])
+	}
+	if len(parts) >= 8 {
+		u.SuspendedUntil = strings.TrimSpace(parts[7])
+	}
+	if len(parts) >= 9 {
+		u.Username = strings.TrimSpace(parts[8])
+	}
+	if len(parts) >= 10 {
+		u.DisplayName = strings.TrimSpace(parts[9])
+	}
+	if len(parts) >= 11 {
+		u.Contact = strings.TrimSpace(parts[10])
+	}
+	if len(parts) >= 12 {
+		u.Owner = strings.TrimSpace(parts[11])
+	}
+	if len(parts) >= 13 {
+		u.TelegramID, _ = strconv.ParseInt(strings.TrimSpace(parts[12]), 10, 64)
+	}
+	if len(parts) >= 14 {
+		u.CreatedAt = strings.TrimSpace(parts[13])
+	}
+	if u.Status == "" {
+		u.Status = StatusActive
+	}
+	return u, true
+}
+
+func formatUserLine(u UserRecord) string {
+	status := u.Status
+	if status == "" {
+		status = StatusActive
+	}
+	// "|" adalah pemisah kolom sehingga tidak boleh muncul di teks bebas
+	clean := func(v string) string { return strings.ReplaceAll(v, "|", "/") }
+	return fmt.Sprintf("%s | %s | %d | %d | %d | %s | %s | %s | %s | %s | %s | %s | %d | %s",
+		u.Password, u.Expired, u.LimitIP, u.LimitQuota, u.UsageBytes, status, clean(u.Reason), u.SuspendedUntil,
+		u.Username, clean(u.DisplayName), clean(u.Contact), u.Owner, u.TelegramID, u.CreatedAt)
+}
+
+// lookupUser mencari user berdasarkan username. Password dipakai sebagai
+// pengenal cadangan untuk client lama yang belum mengirim username.
+func lookupUser(users []UserRecord, username, password string) int {
+	if username != "" {
+		return findUsername(users, username)
+	}
+	if password != "" {
+		return findUser(users, password)
+	}
+	return -1
+}
+
+// lookupOwnedUser seperti lookupUser, tetapi user milik reseller lain
+// dianggap tidak ada agar reseller tidak bisa menebak user orang lain.
+func lookupOwnedUser(users []UserRecord, key ApiKey, username, password string) int {
+	idx := lookupUser(users, username, password)
+	if idx >= 0 && !ownsUser(key, users[idx]) {
+		return -1
+	}
+	return idx
+}
+
+// ownsUser bernilai true untuk key admin atau reseller pemilik user.
+func ownsUser(key ApiKey, u UserRecord) bool {
+	return key.Reseller == "" || u.Owner == key.Reseller
+}
+
+func findUsername(users []UserRecord, username string) int {
+	for i := range users {
+		if users[i].Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+// userLabel dipakai di log agar password tidak ikut tercatat.
+func userLabel(users []UserRecord, idx int) string {
+	if idx < 0 || idx >= len(users) {
+		return "(tanpa record)"
+	}
+	return users[idx].Username
+}
+
+func findPassword(list []string, password string) int {
+	for i, p := range list {
+		if p == password {
+			return i
+		}
+	}
+	return -1
+}
+
+// findUser mengembalikan index user dengan password tersebut, atau -1.
+func findUser(users []UserRecord, password string) int {
+	for i := range users {
+		if users[i].Password == password {
+			return i
+		}
+	}
+	return -1
+}
+
+func addPassword(list []string, password string) []string {
+	for _, p := range list {
+		if p == password {
+			return list
+		}
+	}
+	return append(list, password)
+}
+
+func removePassword(list []string, password string) []string {
+	out := []string{}
+	for _, p := range list {
+		if p != password {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func validUsername(username string) bool {
+	if len(username) < 3 || len(username) > 32 {
+		return false
+	}
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_' || c == '-' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// generateUsername membuat username acak yang belum dipakai.
+func generateUsername(users []UserRecord) string {
+	for {
+		b := make([]byte, 3)
+		rand.Read(b)
+		name := "user-" + hex.EncodeToString(b)
+		if findUsername(users, name) < 0 {
+			return name
+		}
+	}
+}
+
+// ensureUsernames memberi username ke record lama (sebelum ada username)
+// sekali saat start agar setiap user punya identitas tetap.
+func ensureUsernames() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	users, err := store.Load()
+	if err != nil {
+		return err
+	}
+	changed := 0
+	for i := range users {
+		if users[i].Username == "" {
+			users[i].Username = generateUsername(users)
+			changed++
+		}
+	}
+	if changed == 0 {
+		return nil
+	}
+	log.Printf("Memberi username ke %d user lama", changed)
+	return store.Save(users)
+}
+
+func validLimits(req UserRequest) bool {
+	if req.LimitIP != nil && *req.LimitIP < 0 {
+		return false
+	}
+	if req.LimitQuota != nil && *req.LimitQuota < 0 {
+		return false
+	}
+	return true
+}
+
+// applyProfile menyalin nama tampilan dan kontak jika dikirim di request.
+func applyProfile(u *UserRecord, req UserRequest) {
+	if req.DisplayName != nil {
+		u.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.Contact != nil {
+		u.Contact = strings.TrimSpace(*req.Contact)
+	}
+	if req.TelegramID != nil {
+		u.TelegramID = *req.TelegramID
+	}
+}
+
+// applyLimits menyalin limit dari request ke record. Field yang tidak
+// dikirim (nil) tidak mengubah nilai yang sudah tersimpan.
+func applyLimits(u *UserRecord, req UserRequest) {
+	if req.LimitIP != nil {
+		u.LimitIP = *req.LimitIP
+	}
+	if req.LimitQuota != nil {
+		u.LimitQuota = *req.LimitQuota
+	}
+}
+
+// applyUserChange membuat perubahan user berlaku di core. Pada mode external
+// core menanyakan API di setiap koneksi sehingga reload tidak diperlukan.
+// Reload berjalan di belakang; kegagalannya tidak menggagalkan perubahan
+// yang sudah tersimpan dan terlihat di /api/service/status.
+func applyUserChange() {
+	config, err := loadConfig()
+	if err == nil && config.Auth.Mode == AuthModeExternal {
+		return
+	}
+	restarts.Request(false)
+}
+
+// parseExpiry membaca expired RFC3339. Format lama berupa tanggal
+// ("2006-01-02") atau tanggal+jam ("2006-01-02 15:04:05") dibaca dalam
+// waktu lokal server, sesuai cara nilai tersebut dulu ditulis.
+func parseExpiry(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, err
+	}
+	// Expired berupa tanggal saja berlaku sampai akhir hari tersebut
+	return t.AddDate(0, 0, 1), nil
+}
+
+// formatExpiry adalah format expired yang disimpan: RFC3339 dengan zona
+// waktu settings.Timezone.
+func formatExpiry(t time.Time) string {
+	return t.In(displayLoc).Format(time.RFC3339)
+}
+
+// displayExpiry menulis ulang expired tersimpan ke zona waktu tampilan.
+// Nilai yang tidak bisa dibaca dikembalikan apa adanya.
+func displayExpiry(s string) string {
+	t, err := parseExpiry(s)
+	if err != nil {
+		return s
+	}
+	return formatExpiry(t)
+}
+
+// normalizeExpiries mengubah expired format lama ke RFC3339 sekali saat
+// start sehingga database hanya berisi satu format.
+func normalizeExpiries() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	users, err := store.Load()
+	if err != nil {
+		return err
+	}
+	changed := 0
+	for i := range users {
+		if users[i].Expired == "" {
+			continue
+		}
+		if v := displayExpiry(users[i].Expired); v != users[i].Expired {
+			users[i].Expired = v
+			changed++
+		}
+	}
+	if changed == 0 {
+		return nil
+	}
+	log.Printf("Mengubah format expired %d user ke RFC3339", changed)
+	return store.Save(users)
+}
+
+func isExpired(u UserRecord, now time.Time) bool {
+	exp, err := parseExpiry(u.Expired)
+	if err != nil {
+		return false
+	}
+	return !now.Before(exp)
+}
+
+// clientIP mengambil IP dari addr "ip:port" yang dikirim core.
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// corePort mengambil port UDP core dari Config.Listen (contoh ":5667").
+func corePort() string {
+	config, err := loadConfig()
+	if err != nil {
+		return DefaultCorePort
+	}
+	_, port, err := net.SplitHostPort(config.Listen)
+	if err != nil || port == "" {
+		return DefaultCorePort
+	}
+	return port
+}
+
+// --- User Store ---
+
+func openStore() (UserStore, error) {
+	backend := "file"
+	if b, err := ioutil.ReadFile(StorageFile); err == nil {
+		backend = strings.TrimSpace(string(b))
+	}
+
+	switch backend {
+	case "", "file":
+		return &fileStore{path: UserDB}, nil
+	case "bolt":
+		bs, err := openBoltStore(UserBoltDB)
+		if err != nil {
+			return nil, err
+		}
+		if err := migrateToBolt(bs); err != nil {
+			return nil, fmt.Errorf("migrasi ke bolt gagal: %v", err)
+		}
+		return bs, nil
+	default:
+		return nil, fmt.Errorf("backend storage tidak dikenal: %s", backend)
+	}
+}
+
+// fileStore menyimpan user sebagai teks di users.db, satu user per baris.
+type fileStore struct {
+	path string
+}
+
+func (s *fileStore) Load() ([]UserRecord, error) {
+	file, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []UserRecord{}, nil
+		}
+		return nil, err
+	}
+	lines := strings.Split(string(file), "\n")
+	var result []UserRecord
+	for _, line := range lines {
+		if u, ok := parseUserLine(line); ok {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+func (s *fileStore) Save(users []UserRecord) error {
+	lines := make([]string, 0, len(users))
+	for _, u := range users {
+		lines = append(lines, formatUserLine(u))
+	}
+	data := strings.Join(lines, "\n") + "\n"
+	return writeFileAtomic(s.path, []byte(data), 0644)
+}
+
+var (
+	boltUsersBucket = []byte("users")
+	boltMetaBucket  = []byte("meta")
+	boltMigratedKey = []byte("migrated")
+)
+
+// boltStore menyimpan setiap user sebagai JSON di bucket "users" dengan
+// username sebagai key.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltUsersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Load() ([]UserRecord, error) {
+	result := []UserRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+			var u UserRecord
+			if err := json.Unmarshal(v, &u); err != nil {
+				return fmt.Errorf("record %s rusak: %v", k, err)
+			}
+			result = append(result, u)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStore) Save(users []UserRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltUsersBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(boltUsersBucket)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if err := putBoltUser(b, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putBoltUser(b *bolt.Bucket, u UserRecord) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(u.Username), data)
+}
+
+// migrateToBolt mengimpor users.db ke database bolt satu kali saja.
+// Password yang hanya ada di config.json tidak diimpor karena record tanpa
+// expired tidak pernah kedaluwarsa; password itu dilaporkan reconcileUsers
+// dan bisa diimpor di sana dengan masa aktif. users.db lama tidak dihapus.
+func migrateToBolt(s *boltStore) error {
+	var migrated bool
+	s.db.View(func(tx *bolt.Tx) error {
+		migrated = tx.Bucket(boltMetaBucket).Get(boltMigratedKey) != nil
+		return nil
+	})
+	if migrated {
+		return nil
+	}
+
+	users, err := (&fileStore{path: UserDB}).Load()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, u := range users {
+		known[u.Password] = true
+	}
+	configOnly := 0
+	if config, err := loadConfig(); err == nil {
+		for _, p := range config.Auth.Config {
+			if !known[p] {
+				configOnly++
+			}
+		}
+	}
+
+	for i := range users {
+		if users[i].Username == "" {
+			users[i].Username = generateUsername(users)
+		}
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltUsersBucket)
+		for _, u := range users {
+			if err := putBoltUser(b, u); err != nil {
+				return err
+			}
+		}
+		stamp := time.Now().Format(time.RFC3339)
+		return tx.Bucket(boltMetaBucket).Put(boltMigratedKey, []byte(stamp))
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrasi ke bolt selesai: %d user diimpor dari %s", len(users), UserDB)
+	if configOnly > 0 {
+		log.Printf("%d password hanya ada di %s dan tidak diimpor, gunakan /api/reconcile", configOnly, ConfigFile)
+	}
+	return nil
+}
+
+// --- Traffic Accounting ---
+
+// trafficAccounting memasang sepasang rule iptables (masuk dan keluar) untuk
+// setiap IP client yang lolos auth, lalu secara berkala membaca dan me-reset
+// counternya. Byte yang terbaca dijumlahkan ke pemilik IP tersebut dan
+// ditampung di pending sampai disimpan ke database user oleh flushUsage.
+// Hanya IPv4 yang dihitung.
+type trafficAccounting struct {
+	mu      sync.Mutex
+	runner  CommandRunner
+	port    string
+	enabled bool
+	ips     map[string]*trackedIP
+	pending map[string]int64
+}
+
+type trackedIP struct {
+	username string
+	lastSeen time.Time
+}
+
+func newTrafficAccounting(r CommandRunner, port string) *trafficAccounting {
+	return &trafficAccounting{
+		runner:  r,
+		port:    port,
+		ips:     make(map[string]*trackedIP),
+		pending: make(map[string]int64),
+	}
+}
+
+// Setup membuat chain akuntansi (kosong) dan memasangnya di INPUT/OUTPUT.
+func (a *trafficAccounting) Setup() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// -N gagal jika chain sudah ada, itu normal
+	a.runner.Run("iptables", "-N", AcctChain)
+	if out, err := a.runner.Run("iptables", "-F", AcctChain); err != nil {
+		return fmt.Errorf("iptables -F %s: %v (%s)", AcctChain, err, strings.TrimSpace(string(out)))
+	}
+	for _, parent := range []string{"INPUT", "OUTPUT"} {
+		if _, err := a.runner.Run("iptables", "-C", parent, "-j", AcctChain); err == nil {
+			continue
+		}
+		if out, err := a.runner.Run("iptables", "-I", parent, "-j", AcctChain); err != nil {
+			return fmt.Errorf("iptables -I %s: %v (%s)", parent, err, strings.TrimSpace(string(out)))
+		}
+	}
+	a.enabled = true
+	return nil
+}
+
+// Track mencatat bahwa addr (ip:port dari core) dipakai oleh username.
+// Rule counter hanya dipasang saat IP pertama kali terlihat.
+func (a *trafficAccounting) Track(username, addr string) error {
+	host := clientIP(addr)
+	if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled {
+		return nil
+	}
+	if t, ok := a.ips[host]; ok {
+		t.username = username
+		t.lastSeen = time.Now()
+		return nil
+	}
+	for _, args := range a.ruleArgs("-A", host) {
+		if out, err := a.runner.Run("iptables", args...); err != nil {
+			return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(out)))
+		}
+	}
+	a.ips[host] = &trackedIP{username: username, lastSeen: time.Now()}
+	return nil
+}
+
+func (a *trafficAccounting) ruleArgs(op, ip string) [][]string {
+	return [][]string{
+		{op, AcctChain, "-p", "udp", "--dport", a.port, "-s", ip, "-j", "RETURN"},
+		{op, AcctChain, "-p", "udp", "--sport", a.port, "-d", ip, "-j", "RETURN"},
+	}
+}
+
+// Collect membaca counter chain sekaligus me-reset-nya (-Z), menambahkan
+// hasilnya ke pending, dan melepas rule IP yang sudah lama tidak aktif.
+func (a *trafficAccounting) Collect() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled {
+		return nil
+	}
+	out, err := a.runner.Run("iptables", "-L", AcctChain, "-n", "-v", "-x", "-Z")
+	if err != nil {
+		return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(out)))
+	}
+
+	now := time.Now()
+	for ip, bytes := range parseAcctCounters(string(out)) {
+		t, ok := a.ips[ip]
+		if !ok || bytes == 0 {
+			continue
+		}
+		a.pending[t.username] += bytes
+		t.lastSeen = now
+		// IP yang masih mengirim trafik tetap dihitung sebagai device aktif
+		devices.Touch(t.username, ip, now)
+	}
+
+	for ip, t := range a.ips {
+		if now.Sub(t.lastSeen) < AcctIdleTimeout {
+			continue
+		}
+		for _, args := range a.ruleArgs("-D", ip) {
+			a.runner.Run("iptables", args...)
+		}
+		delete(a.ips, ip)
+	}
+	return nil
+}
+
+// parseAcctCounters menjumlahkan kolom bytes per IP dari output
+// "iptables -L -n -v -x". Kolom: pkts bytes target prot opt in out source destination.
+func parseAcctCounters(out string) map[string]int64 {
+	result := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 9 {
+			continue
+		}
+		bytes, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		ip := f[7]
+		if ip == "0.0.0.0/0" {
+			ip = f[8]
+		}
+		if ip == "0.0.0.0/0" {
+			continue
+		}
+		result[ip] += bytes
+	}
+	return result
+}
+
+// Drain mengambil dan mengosongkan pending.
+func (a *trafficAccounting) Drain() map[string]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := a.pending
+	a.pending = make(map[string]int64)
+	return out
+}
+
+// Restore mengembalikan pending yang gagal disimpan.
+func (a *trafficAccounting) Restore(usage map[string]int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for p, b := range usage {
+		a.pending[p] += b
+	}
+}
+
+// Reset membuang pending milik username, dipakai saat pemakaian di-reset.
+func (a *trafficAccounting) Reset(username string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, username)
+}
+
+// Pending mengembalikan byte yang sudah terbaca tapi belum disimpan.
+func (a *trafficAccounting) Pending(username string) int64 {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pending[username]
+}
+
+// IPs mengembalikan IP client yang sedang dihitung untuk username.
+func (a *trafficAccounting) IPs(username string) []string {
+	ips := []string{}
+	if a == nil {
+		return ips
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ip, t := range a.ips {
+		if t.username == username {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// flushUsage membaca counter kernel lalu menambahkan pemakaian ke record
+// user di database.
+func flushUsage() error {
+	if err := accounting.Collect(); err != nil {
+		return err
+	}
+	usage := accounting.Drain()
+	if len(usage) == 0 {
+		return nil
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	users, err := store.Load()
+	if err != nil {
+		accounting.Restore(usage)
+		return err
+	}
+	for i := range users {
+		users[i].UsageBytes += usage[users[i].Username]
+	}
+	if err := store.Save(users); err != nil {
+		accounting.Restore(usage)
+		return err
+	}
+	return nil
+}
+
+// --- Policy Enforcer ---
+
+func quotaExceeded(u UserRecord) bool {
+	return u.LimitQuota > 0 && u.UsageBytes >= int64(u.LimitQuota)*bytesPerGigabyte
+}
+
+func lockoutOver(u UserRecord, now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, u.SuspendedUntil)
+	return err != nil || !now.Before(until)
+}
+
+// enforcePolicies menerapkan aturan otomatis ke semua user: suspend jika
+// kuota habis, aktifkan kembali jika kuota sudah ditambah (atau limit
+// dihapus) dan akhiri suspend limit IP yang masa lock-out-nya sudah lewat.
+func enforcePolicies() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	users, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	suspended := 0
+	reactivated := 0
+	var pending []Event
+	for i := range users {
+		u := &users[i]
+		switch {
+		case u.Status != StatusSuspended && quotaExceeded(*u):
+			u.Status = StatusSuspended
+			u.Reason = ReasonQuota
+			config.Auth.Config = removePassword(config.Auth.Config, u.Password)
+			suspended++
+			pending = append(pending, Event{Type: EventUserSuspended, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{
+				"reason":      ReasonQuota,
+				"usage_bytes": u.UsageBytes,
+				"limit_quota": u.LimitQuota,
+			}})
+			log.Printf("User %s di-suspend: kuota %d GB habis", u.Username, u.LimitQuota)
+		case u.Status == StatusSuspended && u.Reason == ReasonQuota && !quotaExceeded(*u):
+			u.Status = StatusActive
+			u.Reason = ""
+			config.Auth.Config = addPassword(config.Auth.Config, u.Password)
+			reactivated++
+			pending = append(pending, Event{Type: EventUserReactivated, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"reason": ReasonQuota}})
+			log.Printf("User %s aktif kembali: kuota ditambah", u.Username)
+		case u.Status == StatusSuspended && u.Reason == ReasonLimitIP && lockoutOver(*u, time.Now()):
+			u.Status = StatusActive
+			u.Reason = ""
+			u.SuspendedUntil = ""
+			config.Auth.Config = addPassword(config.Auth.Config, u.Password)
+			reactivated++
+			pending = append(pending, Event{Type: EventUserReactivated, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"reason": ReasonLimitIP}})
+			log.Printf("User %s aktif kembali: masa lock-out limit IP selesai", u.Username)
+		}
+	}
+	if suspended == 0 && reactivated == 0 {
+		return nil
+	}
+
+	if err := saveConfigAndUsers(config, users); err != nil {
+		return err
+	}
+	for _, e := range pending {
+		events.Publish(e)
+	}
+	// Mode external hanya mengecek auth saat koneksi baru, jadi restart
+	// tetap diperlukan untuk memutus sesi user yang kuotanya habis.
+	if suspended > 0 {
+		restarts.Request(true)
+		return nil
+	}
+	applyUserChange()
+	return nil
+}
+
+// enforceExpiry menonaktifkan user yang expired (suspend dengan alasan
+// ReasonExpired) dan menghapusnya setelah masa tenggang jika PurgePolicy
+// PurgeDelete. Dengan force, semua user expired langsung dihapus.
+// Username yang dihapus dikembalikan.
+func enforceExpiry(force bool) (purged []string, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	disabled := 0
+	defer func() { metrics.ExpiryRun(len(purged), disabled, err) }()
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	users, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	grace, _ := parseSpan(settings.GracePeriod)
+	now := time.Now()
+	kept := make([]UserRecord, 0, len(users))
+	var pending []Event
+	for _, u := range users {
+		exp, err := parseExpiry(u.Expired)
+		if err != nil || now.Before(exp) {
+			kept = append(kept, u)
+			continue
+		}
+
+		if force || (settings.PurgePolicy == PurgeDelete && !now.Before(exp.Add(grace))) {
+			config.Auth.Config = removePassword(config.Auth.Config, u.Password)
+			accounting.Reset(u.Username)
+			purged = append(purged, u.Username)
+			pending = append(pending, Event{Type: EventUserPurged, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"expired": u.Expired}})
+			log.Printf("User expired %s (Exp: %s) dihapus", u.Username, u.Expired)
+			continue
+		}
+
+		if u.Status != StatusSuspended {
+			u.Status = StatusSuspended
+			u.Reason = ReasonExpired
+			u.SuspendedUntil = ""
+			config.Auth.Config = removePassword(config.Auth.Config, u.Password)
+			data := map[string]interface{}{"expired": u.Expired}
+			if settings.PurgePolicy == PurgeDelete {
+				data["purge_at"] = exp.Add(grace).Format(time.RFC3339)
+			}
+			pending = append(pending, Event{Type: EventUserExpired, Username: u.Username, Owner: u.Owner, Data: data})
+			disabled++
+			log.Printf("User expired %s (Exp: %s) dinonaktifkan, masa tenggang %s", u.Username, u.Expired, settings.GracePeriod)
+		}
+		kept = append(kept, u)
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	if err := saveConfigAndUsers(config, kept); err != nil {
+		disabled = 0
+		return nil, err
+	}
+	for _, e := range pending {
+		events.Publish(e)
+	}
+	// pending hanya terisi jika ada user yang dinonaktifkan atau dihapus;
+	// seperti suspend kuota, sesinya hanya terputus lewat restart penuh
+	restarts.Request(true)
+	return purged, nil
+}
+
+// purgeExpiredUsers langsung menghapus semua user expired tanpa menunggu
+// masa tenggang. Restart service dijadwalkan enforceExpiry jika ada user
+// yang dihapus.
+func purgeExpiredUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	purged, err := enforceExpiry(true)
+	if err != nil {
+		log.Printf("Gagal menghapus user expired: %v", err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menghapus user expired", nil)
+		return
+	}
+	if len(purged) == 0 {
+		jsonResponse(w, http.StatusOK, true, "Tidak ada user expired", []string{})
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, fmt.Sprintf("%d user expired dihapus, restart service dijadwalkan", len(purged)), purged)
+}
+
+// --- Device Limit ---
+
+// deviceTracker mencatat IP client per user beserta waktu terakhir terlihat.
+// Sebuah IP dihitung sebagai device aktif selama masih terlihat di dalam
+// window (dari auth callback atau trafik di counter akuntansi).
+type deviceTracker struct {
+	mu   sync.Mutex
+	seen map[string]map[string]time.Time
+}
+
+func newDeviceTracker() *deviceTracker {
+	return &deviceTracker{seen: make(map[string]map[string]time.Time)}
+}
+
+// Admit memutuskan apakah ip boleh dipakai username dengan batas limit IP
+// berbeda dalam window. Jika ditolak, IP aktif saat ini dikembalikan.
+func (d *deviceTracker) Admit(username, ip string, limit int, window time.Duration, now time.Time) ([]string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ips := d.seen[username]
+	if ips == nil {
+		ips = make(map[string]time.Time)
+		d.seen[username] = ips
+	}
+	for addr, last := range ips {
+		if now.Sub(last) > window {
+			delete(ips, addr)
+		}
+	}
+
+	if _, ok := ips[ip]; ok || len(ips) < limit {
+		ips[ip] = now
+		return nil, true
+	}
+
+	active := make([]string, 0, len(ips))
+	for addr := range ips {
+		active = append(active, addr)
+	}
+	return active, false
+}
+
+// Touch memperbarui waktu terakhir IP yang sudah tercatat.
+func (d *deviceTracker) Touch(username, ip string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ips, ok := d.seen[username]; ok {
+		if _, ok := ips[ip]; ok {
+			ips[ip] = now
+		}
+	}
+}
+
+// Violation adalah satu catatan pelanggaran limit IP di ViolationLog.
+type Violation struct {
+	Time      string   `json:"time"`
+	Username  string   `json:"username"`
+	IP        string   `json:"ip"`
+	ActiveIPs []string `json:"active_ips"`
+	LimitIP   int      `json:"limit_ip"`
+	Action    string   `json:"action"`
+}
+
+func recordViolation(v Violation) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ViolationLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func loadViolations() ([]Violation, error) {
+	data, err := ioutil.ReadFile(ViolationLog)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Violation{}, nil
+		}
+		return nil, err
+	}
+	result := []Violation{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var v Violation
+		if err := json.Unmarshal([]byte(line), &v); err == nil {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// --- API Key ---
+
+// ApiKey adalah satu key di ApiKeysFile. Key asli hanya ditampilkan sekali
+// saat dibuat; yang disimpan hanya hash SHA-256-nya.
+type ApiKey struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Scope      string `json:"scope"`
+	Reseller   string `json:"reseller,omitempty"` // key milik reseller, lihat Reseller
+	Hash       string `json:"hash,omitempty"`
+	Prefix     string `json:"prefix"`
+	CreatedAt  string `json:"created_at"`
+	ExpiresAt  string `json:"expires_at,omitempty"` // RFC3339, kosong = tanpa batas
+	RevokedAt  string `json:"revoked_at,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	LastUsedIP string `json:"last_used_ip,omitempty"`
+}
+
+type apiKeyContext struct{}
+
+// requestKey mengembalikan API key yang dipakai request.
+func requestKey(r *http.Request) ApiKey {
+	key, _ := r.Context().Value(apiKeyContext{}).(ApiKey)
+	return key
+}
+
+var scopeLevel = map[string]int{ScopeRead: 1, ScopeUserWrite: 2, ScopeAdmin: 3}
+
+func scopeAllows(have, need string) bool {
+	return scopeLevel[have] > 0 && scopeLevel[have] >= scopeLevel[need]
+}
+
+type apiKeyStore struct {
+	mu   sync.Mutex
+	path string
+	keys []ApiKey
+}
+
+func loadApiKeys(path string) (*apiKeyStore, error) {
+	s := &apiKeyStore{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *apiKeyStore) save() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+func hashApiKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasActive melaporkan apakah ada key yang belum dicabut dan belum expired.
+func (s *apiKeyStore) HasActive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, k := range s.keys {
+		if k.RevokedAt != "" {
+			continue
+		}
+		if exp, err := time.Parse(time.RFC3339, k.ExpiresAt); err == nil && now.After(exp) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Authenticate mencocokkan token dengan key legacy atau key di store.
+// Key yang dicabut atau sudah lewat ExpiresAt ditolak.
+func (s *apiKeyStore) Authenticate(token, ip string) (ApiKey, bool) {
+	if token == "" {
+		return ApiKey{}, false
+	}
+	if AuthToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(AuthToken)) == 1 {
+		return ApiKey{ID: LegacyKeyID, Name: "apikey", Scope: ScopeAdmin}, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := hashApiKey(token)
+	now := time.Now()
+	for i := range s.keys {
+		k := &s.keys[i]
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if k.RevokedAt != "" {
+			return ApiKey{}, false
+		}
+		if k.ExpiresAt != "" {
+			if exp, err := time.Parse(time.RFC3339, k.ExpiresAt); err == nil && now.After(exp) {
+				return ApiKey{}, false
+			}
+		}
+		// Last-used cukup akurat per menit agar file tidak ditulis di setiap request
+		last, _ := time.Parse(time.RFC3339, k.LastUsedAt)
+		if now.Sub(last) >= time.Minute || k.LastUsedIP != ip {
+			k.LastUsedAt = now.Format(time.RFC3339)
+			k.LastUsedIP = ip
+			if err := s.save(); err != nil {
+				log.Printf("Gagal menyimpan last-used API key %s: %v", k.ID, err)
+			}
+		}
+		return *k, true
+	}
+	return ApiKey{}, false
+}
+
+// Create membuat key baru dan mengembalikan token aslinya.
+func (s *apiKeyStore) Create(name, scope, reseller, expiresAt string) (ApiKey, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return ApiKey{}, "", err
+	}
+	token := "zk_" + hex.EncodeToString(b)
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return ApiKey{}, "", err
+	}
+
+	key := ApiKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Scope:     scope,
+		Reseller:  reseller,
+		Hash:      hashApiKey(token),
+		Prefix:    token[:7],
+		CreatedAt: time.Now().Format(time.RFC3339),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return ApiKey{}, "", err
+	}
+	return key, token, nil
+}
+
+// Revoke menandai key dicabut. Key tetap tersimpan agar audit tetap terbaca.
+func (s *apiKeyStore) Revoke(id string) (ApiKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.keys {
+		if s.keys[i].ID != id {
+			continue
+		}
+		if s.keys[i].RevokedAt == "" {
+			s.keys[i].RevokedAt = time.Now().Format(time.RFC3339)
+			if err := s.save(); err != nil {
+				s.keys[i].RevokedAt = ""
+				return ApiKey{}, true, err
+			}
+		}
+		return s.keys[i], true, nil
+	}
+	return ApiKey{}, false, nil
+}
+
+// RevokeReseller mencabut semua key milik reseller.
+func (s *apiKeyStore) RevokeReseller(reseller string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Format(time.RFC3339)
+	for i := range s.keys {
+		if s.keys[i].Reseller == reseller && s.keys[i].RevokedAt == "" {
+			s.keys[i].RevokedAt = now
+		}
+	}
+	return s.save()
+}
+
+// List mengembalikan semua key tanpa hash.
+func (s *apiKeyStore) List() []ApiKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]ApiKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		k.Hash = ""
+		result = append(result, k)
+	}
+	return result
+}
+
+func listApiKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Daftar API key", apiKeys.List())
+}
+
+func createApiKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req struct {
+		Name      string `json:"name"`
+		Scope     string `json:"scope"`
+		Days      int    `json:"days"`
+		ExpiresAt string `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		jsonResponse(w, http.StatusBadRequest, false, "Nama key harus diisi", nil)
+		return
+	}
+	if scopeLevel[req.Scope] == 0 {
+		jsonResponse(w, http.StatusBadRequest, false, "Scope harus read, user-write atau admin", nil)
+		return
+	}
+
+	expiresAt := ""
+	if req.ExpiresAt != "" {
+		exp, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, false, "Format expires_at harus RFC3339", nil)
+			return
+		}
+		expiresAt = exp.Format(time.RFC3339)
+	} else if req.Days > 0 {
+		expiresAt = time.Now().AddDate(0, 0, req.Days).Format(time.RFC3339)
+	}
+
+	key, token, err := apiKeys.Create(req.Name, req.Scope, "", expiresAt)
+	if err != nil {
+		log.Printf("Gagal membuat API key %s: %v", req.Name, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan API key", nil)
+		return
+	}
+	key.Hash = ""
+	jsonResponse(w, http.StatusOK, true, "API key berhasil dibuat, simpan key ini karena tidak akan ditampilkan lagi", map[string]interface{}{
+		"key":     token,
+		"api_key": key,
+	})
+}
+
+func revokeApiKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	if req.ID == LegacyKeyID {
+		jsonResponse(w, http.StatusBadRequest, false, "Key legacy diganti lewat file "+ApiKeyFile, nil)
+		return
+	}
+
+	key, found, err := apiKeys.Revoke(req.ID)
+	if !found {
+		jsonResponse(w, http.StatusNotFound, false, "API key tidak ditemukan", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Gagal mencabut API key %s: %v", req.ID, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan API key", nil)
+		return
+	}
+	key.Hash = ""
+	jsonResponse(w, http.StatusOK, true, "API key berhasil dicabut", key)
+}
+
+// AuditEntry adalah satu request yang tercatat di ApiAuditLog.
+type AuditEntry struct {
+	Time   string `json:"time"`
+	KeyID  string `json:"key_id"`
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	IP     string `json:"ip"`
+}
+
+// statusRecorder menyimpan status HTTP yang ditulis handler untuk audit.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush diteruskan agar stream SSE tetap bisa di-flush lewat middleware.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var (
+	auditMutex sync.Mutex
+	// auditLines adalah jumlah baris di ApiAuditLog; -1 jika belum dihitung.
+	auditLines = -1
+)
+
+// recordAudit menambah e ke ApiAuditLog. Seperti log event, jika log sudah
+// dua kali AuditLogSize, log ditulis ulang hanya berisi AuditLogSize entri
+// terakhir agar file (dan listApiAudit yang membacanya) tidak terus membesar.
+func recordAudit(e AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	if auditLines < 0 || auditLines >= 2*AuditLogSize {
+		if err := compactAuditLog(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(ApiAuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	auditLines++
+	return nil
+}
+
+// compactAuditLog menghitung baris ApiAuditLog dan memangkasnya ke
+// AuditLogSize entri terakhir jika perlu. Dipanggil dengan auditMutex.
+func compactAuditLog() error {
+	data, err := ioutil.ReadFile(ApiAuditLog)
+	if err != nil {
+		if os.IsNotExist(err) {
+			auditLines = 0
+			return nil
+		}
+		return err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 2*AuditLogSize {
+		auditLines = len(lines)
+		return nil
+	}
+	lines = lines[len(lines)-AuditLogSize:]
+	if err := writeFileAtomic(ApiAuditLog, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	auditLines = len(lines)
+	return nil
+}
+
+func listApiAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	keyID := r.URL.Query().Get("id")
+
+	auditMutex.Lock()
+	data, err := ioutil.ReadFile(ApiAuditLog)
+	auditMutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca log audit", nil)
+		return
+	}
+
+	// Terbaru lebih dulu
+	lines := strings.Split(string(data), "\n")
+	result := []AuditEntry{}
+	for i := len(lines) - 1; i >= 0 && len(result) < limit; i-- {
+		var e AuditEntry
+		if strings.TrimSpace(lines[i]) == "" || json.Unmarshal([]byte(lines[i]), &e) != nil {
+			continue
+		}
+		if keyID != "" && e.KeyID != keyID {
+			continue
+		}
+		result = append(result, e)
+	}
+	jsonResponse(w, http.StatusOK, true, "Log audit API", result)
+}
+
+// --- Reseller ---
+
+// Reseller memiliki user yang dibuat dengan API key-nya. Saldo (kredit)
+// dipotong PricePerDay untuk setiap hari akun yang dibuat atau diperpanjang.
+type Reseller struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Balance     int64  `json:"balance"`
+	PricePerDay int64  `json:"price_per_day"`
+	MaxAccounts int    `json:"max_accounts"` // 0 = tanpa batas
+	CreatedAt   string `json:"created_at"`
+}
+
+type resellerStore struct {
+	mu   sync.Mutex
+	path string
+	list []Reseller
+}
+
+func loadResellers(path string) (*resellerStore, error) {
+	s := &resellerStore{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.list); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *resellerStore) save() error {
+	data, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+func (s *resellerStore) find(id string) int {
+	for i := range s.list {
+		if s.list[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *resellerStore) Get(id string) (Reseller, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(id); i >= 0 {
+		return s.list[i], true
+	}
+	return Reseller{}, false
+}
+
+func (s *resellerStore) List() []Reseller {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Reseller{}, s.list...)
+}
+
+// Update menjalankan fn pada reseller lalu menyimpan. Perubahan dibatalkan
+// jika fn mengembalikan error atau penyimpanan gagal.
+func (s *resellerStore) Update(id string, fn func(*Reseller) error) (Reseller, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return Reseller{}, errResellerNotFound
+	}
+	old := s.list[i]
+	if err := fn(&s.list[i]); err != nil {
+		s.list[i] = old
+		return old, err
+	}
+	if err := s.save(); err != nil {
+		s.list[i] = old
+		return old, err
+	}
+	return s.list[i], nil
+}
+
+// Debit memotong saldo reseller dan gagal jika saldo tidak cukup.
+func (s *resellerStore) Debit(id string, amount int64) error {
+	_, err := s.Update(id, func(rs *Reseller) error {
+		if rs.Balance < amount {
+			return fmt.Errorf("Saldo reseller tidak cukup: butuh %d, sisa %d", amount, rs.Balance)
+		}
+		rs.Balance -= amount
+		return nil
+	})
+	return err
+}
+
+var errResellerNotFound = fmt.Errorf("reseller tidak ditemukan")
+
+// refundReseller mengembalikan saldo yang sudah dipotong saat penyimpanan
+// user gagal.
+func refundReseller(id string, amount int64) {
+	if id == "" || amount == 0 {
+		return
+	}
+	if err := resellers.Debit(id, -amount); err != nil {
+		log.Printf("Gagal mengembalikan saldo %d ke reseller %s: %v", amount, id, err)
+	}
+}
+
+func addResellerBalance(data map[string]interface{}, id string, cost int64) {
+	if id == "" {
+		return
+	}
+	data["cost"] = cost
+	if rs, ok := resellers.Get(id); ok {
+		data["balance"] = rs.Balance
+	}
+}
+
+// billableDays membulatkan durasi ke atas dalam hari, minimal satu hari.
+func billableDays(d time.Duration) int {
+	days := int((d + 24*time.Hour - 1) / (24 * time.Hour))
+	if days < 1 {
+		days = 1
+	}
+	return days
+}
+
+func countOwned(users []UserRecord, owner string) int {
+	n := 0
+	for _, u := range users {
+		if u.Owner == owner {
+			n++
+		}
+	}
+	return n
+}
+
+// ResellerRequest dipakai endpoint create dan update reseller. Field
+// pointer hanya diubah jika dikirim.
+type ResellerRequest struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Balance     int64  `json:"balance"`
+	PricePerDay *int64 `json:"price_per_day"`
+	MaxAccounts *int   `json:"max_accounts"`
+	Amount      int64  `json:"amount"`
+}
+
+func listResellers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	mutex.Lock()
+	users, err := store.Load()
+	mutex.Unlock()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+		return
+	}
+
+	type ResellerInfo struct {
+		Reseller
+		Accounts int `json:"accounts"`
+	}
+	result := []ResellerInfo{}
+	for _, rs := range resellers.List() {
+		result = append(result, ResellerInfo{Reseller: rs, Accounts: countOwned(users, rs.ID)})
+	}
+	jsonResponse(w, http.StatusOK, true, "Daftar reseller", result)
+}
+
+// createReseller membuat reseller baru beserta API key scope user-write
+// yang terikat ke reseller tersebut.
+func createReseller(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req ResellerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		jsonResponse(w, http.StatusBadRequest, false, "Nama reseller harus diisi", nil)
+		return
+	}
+	rs := Reseller{Name: req.Name, Balance: req.Balance, PricePerDay: 1, CreatedAt: time.Now().Format(time.RFC3339)}
+	if req.PricePerDay != nil {
+		rs.PricePerDay = *req.PricePerDay
+	}
+	if req.MaxAccounts != nil {
+		rs.MaxAccounts = *req.MaxAccounts
+	}
+	if rs.Balance < 0 || rs.PricePerDay < 0 || rs.MaxAccounts < 0 {
+		jsonResponse(w, http.StatusBadRequest, false, "Saldo, harga dan batas akun tidak boleh negatif", nil)
+		return
+	}
+	id := make([]byte, 4)
+	rand.Read(id)
+	rs.ID = hex.EncodeToString(id)
+
+	resellers.mu.Lock()
+	resellers.list = append(resellers.list, rs)
+	err := resellers.save()
+	if err != nil {
+		resellers.list = resellers.list[:len(resellers.list)-1]
+	}
+	resellers.mu.Unlock()
+	if err != nil {
+		log.Printf("Gagal menyimpan reseller %s: %v", rs.Name, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan data reseller", nil)
+		return
+	}
+
+	key, token, err := apiKeys.Create("reseller:"+rs.Name, ScopeUserWrite, rs.ID, "")
+	if err != nil {
+		log.Printf("Gagal membuat API key reseller %s: %v", rs.Name, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Reseller dibuat tetapi API key gagal disimpan", rs)
+		return
+	}
+	key.Hash = ""
+	jsonResponse(w, http.StatusOK, true, "Reseller berhasil dibuat, simpan API key ini karena tidak akan ditampilkan lagi", map[string]interface{}{
+		"reseller": rs,
+		"key":      token,
+		"api_key":  key,
+	})
+}
+
+func updateReseller(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req ResellerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+
+	rs, err := resellers.Update(req.ID, func(rs *Reseller) error {
+		if name := strings.TrimSpace(req.Name); name != "" {
+			rs.Name = name
+		}
+		if req.PricePerDay != nil {
+			rs.PricePerDay = *req.PricePerDay
+		}
+		if req.MaxAccounts != nil {
+			rs.MaxAccounts = *req.MaxAccounts
+		}
+		if rs.PricePerDay < 0 || rs.MaxAccounts < 0 {
+			return fmt.Errorf("Harga dan batas akun tidak boleh negatif")
+		}
+		return nil
+	})
+	if err != nil {
+		resellerError(w, err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Reseller berhasil diperbarui", rs)
+}
+
+// topupReseller menambah saldo reseller. Amount negatif dipakai untuk
+// koreksi, tetapi saldo tidak boleh menjadi negatif.
+func topupReseller(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req ResellerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	if req.Amount == 0 {
+		jsonResponse(w, http.StatusBadRequest, false, "Amount tidak boleh 0", nil)
+		return
+	}
+
+	rs, err := resellers.Update(req.ID, func(rs *Reseller) error {
+		if rs.Balance+req.Amount < 0 {
+			return fmt.Errorf("Saldo tidak boleh negatif")
+		}
+		rs.Balance += req.Amount
+		return nil
+	})
+	if err != nil {
+		resellerError(w, err)
+		return
+	}
+	log.Printf("Saldo reseller %s diubah %+d menjadi %d", rs.Name, req.Amount, rs.Balance)
+	jsonResponse(w, http.StatusOK, true, "Saldo reseller diperbarui", rs)
+}
+
+// deleteReseller menghapus reseller dan mencabut API key-nya. User milik
+// reseller tetap ada dan bisa dikelola admin.
+func deleteReseller(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req ResellerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+
+	if err := apiKeys.RevokeReseller(req.ID); err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal mencabut API key reseller", nil)
+		return
+	}
+
+	resellers.mu.Lock()
+	i := resellers.find(req.ID)
+	var err error
+	if i >= 0 {
+		old := resellers.list
+		resellers.list = append(append([]Reseller{}, old[:i]...), old[i+1:]...)
+		if err = resellers.save(); err != nil {
+			resellers.list = old
+		}
+	}
+	resellers.mu.Unlock()
+
+	if i < 0 {
+		jsonResponse(w, http.StatusNotFound, false, "Reseller tidak ditemukan", nil)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan data reseller", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Reseller berhasil dihapus", nil)
+}
+
+// resellerInfo menampilkan saldo dan jumlah akun reseller pemilik key.
+func resellerInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	rs, ok := resellers.Get(requestKey(r).Reseller)
+	if !ok {
+		jsonResponse(w, http.StatusNotFound, false, "API key ini bukan milik reseller", nil)
+		return
+	}
+
+	mutex.Lock()
+	users, err := store.Load()
+	mutex.Unlock()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Info reseller", map[string]interface{}{
+		"reseller": rs,
+		"accounts": countOwned(users, rs.ID),
+	})
+}
+
+func resellerError(w http.ResponseWriter, err error) {
+	if err == errResellerNotFound {
+		jsonResponse(w, http.StatusNotFound, false, "Reseller tidak ditemukan", nil)
+		return
+	}
+	jsonResponse(w, http.StatusBadRequest, false, err.Error(), nil)
+}
+
+// --- Event ---
+
+// Tipe event yang dipublikasikan API. Bot berlangganan event ini untuk
+// notifikasi sehingga logika penegakan cukup ada di API.
+const (
+	EventUserCreated     = "user.created"
+	EventUserDeleted     = "user.deleted"
+	EventUserRenewed     = "user.renewed"
+	EventPasswordChanged = "user.password_changed"
+	EventUserSuspended   = "user.suspended"
+	EventUserUnsuspended = "user.unsuspended"
+	EventUserReactivated = "user.reactivated"
+	EventUserExpired     = "user.expired"
+	EventUserPurged      = "user.purged"
+
+	EventServiceRestarted     = "service.restarted"
+	EventServiceRestartFailed = "service.restart_failed"
+)
+
+type Event struct {
+	ID       int64                  `json:"id"`
+	Time     string                 `json:"time"`
+	Type     string                 `json:"type"`
+	Username string                 `json:"username,omitempty"`
+	Owner    string                 `json:"owner,omitempty"` // reseller pemilik user
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// eventBus menyimpan EventBufferSize event terakhir dengan ID berurutan.
+// Event juga ditulis ke log di disk (satu JSON per baris) agar ID dan
+// riwayat tetap ada setelah restart, lalu dikirim ke subscriber stream.
+type eventBus struct {
+	mu       sync.Mutex
+	path     string // kosong: hanya di memori
+	nextID   int64
+	buf      []Event
+	logLines int
+	subs     map[chan Event]struct{}
+}
+
+// events hanya di memori sampai main memuat EventLogFile.
+var events = &eventBus{nextID: 1, subs: make(map[chan Event]struct{})}
+
+// loadEventBus membaca log event di path. Baris yang rusak dilewati.
+func loadEventBus(path string) (*eventBus, error) {
+	b := &eventBus{path: path, nextID: 1, subs: make(map[chan Event]struct{})}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var e Event
+		if strings.TrimSpace(line) == "" || json.Unmarshal([]byte(line), &e) != nil {
+			continue
+		}
+		b.logLines++
+		b.buf = append(b.buf, e)
+		if e.ID >= b.nextID {
+			b.nextID = e.ID + 1
+		}
+	}
+	if len(b.buf) > EventBufferSize {
+		b.buf = b.buf[len(b.buf)-EventBufferSize:]
+	}
+	return b, nil
+}
+
+// Publish memberi ID dan waktu pada e, menyimpannya lalu mengirimnya ke
+// subscriber. Subscriber yang tertinggal diputus agar Publish tidak
+// pernah menunggu; client menyambung lagi dengan Last-Event-ID.
+func (b *eventBus) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.ID = b.nextID
+	e.Time = time.Now().Format(time.RFC3339)
+	b.nextID++
+	b.buf = append(b.buf, e)
+	if len(b.buf) > EventBufferSize {
+		b.buf = b.buf[len(b.buf)-EventBufferSize:]
+	}
+	if err := b.appendLog(e); err != nil {
+		log.Printf("Gagal menulis log event: %v", err)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// appendLog menambah e ke log. Jika log sudah dua kali EventBufferSize,
+// log ditulis ulang hanya berisi buffer di memori.
+func (b *eventBus) appendLog(e Event) error {
+	if b.path == "" {
+		return nil
+	}
+	if b.logLines >= 2*EventBufferSize {
+		var buf bytes.Buffer
+		for _, ev := range b.buf {
+			line, _ := json.Marshal(ev)
+			buf.Write(append(line, '\n'))
+		}
+		if err := writeFileAtomic(b.path, buf.Bytes(), 0600); err != nil {
+			return err
+		}
+		b.logLines = len(b.buf)
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	b.logLines++
+	return nil
+}
+
+// After mengembalikan event dengan ID lebih besar dari after beserta ID
+// event terakhir yang sudah dibaca. Jika hasil terpotong limit, ID itu
+// adalah event terakhir di hasil sehingga client bisa melanjutkan dari sana.
+func (b *eventBus) After(after int64, limit int) ([]Event, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := []Event{}
+	for _, e := range b.buf {
+		if e.ID > after {
+			result = append(result, e)
+			if len(result) >= limit {
+				return result, e.ID
+			}
+		}
+	}
+	return result, b.nextID - 1
+}
+
+// LastID mengembalikan ID event terakhir.
+func (b *eventBus) LastID() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
+
+// Subscribe mendaftarkan channel untuk event baru. Channel ditutup jika
+// subscriber tertinggal; panggil fungsi yang dikembalikan untuk berhenti.
+func (b *eventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// eventVisible: reseller hanya menerima event milik user-nya dan event
+// sistem (tanpa username).
+func eventVisible(key ApiKey, e Event) bool {
+	return key.Reseller == "" || e.Username == "" || e.Owner == key.Reseller
+}
+
+// listEvents mengembalikan event setelah ?after=ID. Client menyimpan
+// last_id dan mengirimnya di request berikutnya. Dengan header
+// "Accept: text/event-stream" event dikirim sebagai Server-Sent Events.
+func listEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		streamEvents(w, r)
+		return
+	}
+
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	list, lastID := events.After(after, limit)
+	key := requestKey(r)
+	filtered := []Event{}
+	for _, e := range list {
+		if eventVisible(key, e) {
+			filtered = append(filtered, e)
+		}
+	}
+	jsonResponse(w, http.StatusOK, true, "Daftar event", map[string]interface{}{
+		"events":  filtered,
+		"last_id": lastID,
+	})
+}
+
+// streamEvents mengirim event sebagai SSE. Posisi awal diambil dari header
+// Last-Event-ID (saat client menyambung ulang) atau ?after=ID; tanpa
+// keduanya hanya event baru yang dikirim.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonResponse(w, http.StatusInternalServerError, false, "Streaming tidak didukung", nil)
+		return
+	}
+
+	// Subscribe sebelum membaca backlog agar tidak ada event yang terlewat
+	ch, cancel := events.Subscribe()
+	defer cancel()
+
+	after := events.LastID()
+	if v := r.URL.Query().Get("after"); v != "" {
+		after, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		after, _ = strconv.ParseInt(v, 10, 64)
+	}
+	// ID di depan log berarti log event sudah direset: kirim ulang dari awal
+	if after > events.LastID() {
+		after = 0
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	key := requestKey(r)
+	send := func(e Event) error {
+		if e.ID <= after {
+			return nil
+		}
+		after = e.ID
+		if !eventVisible(key, e) {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err
+	}
+
+	for {
+		list, _ := events.After(after, 500)
+		for _, e := range list {
+			if send(e) != nil {
+				return
+			}
+		}
+		if len(list) < 500 {
+			break
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(EventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				// Tertinggal terlalu jauh: client menyambung lagi dengan Last-Event-ID
+				return
+			}
+			if send(e) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// --- Webhook ---
+
+// Webhook menerima event (semua, atau hanya tipe di Events) sebagai JSON.
+// Setiap request ditandatangani HMAC-SHA256 dengan Secret.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// WebhookDelivery adalah satu event untuk satu webhook beserta status
+// pengirimannya.
+type WebhookDelivery struct {
+	ID          string `json:"id"`
+	WebhookID   string `json:"webhook_id"`
+	Event       Event  `json:"event"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt string `json:"next_attempt,omitempty"`
+	LastStatus  int    `json:"last_status,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type WebhookRequest struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	DeliveryID string   `json:"delivery_id"`
+}
+
+// webhookData adalah isi WebhooksFile. LastEventID adalah event terakhir
+// yang sudah dimasukkan ke antrian sehingga tidak ada event yang terlewat
+// atau terkirim dua kali setelah API restart.
+type webhookData struct {
+	Webhooks    []Webhook         `json:"webhooks"`
+	Deliveries  []WebhookDelivery `json:"deliveries"`
+	LastEventID int64             `json:"last_event_id"`
+}
+
+type webhookStore struct {
+	mu     sync.Mutex
+	path   string
+	data   webhookData
+	wake   chan struct{}
+	client *http.Client
+}
+
+func loadWebhooks(path string) (*webhookStore, error) {
+	s := &webhookStore{
+		path:   path,
+		wake:   make(chan struct{}, 1),
+		client: &http.Client{Timeout: WebhookTimeout},
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Webhook baru tidak menerima riwayat event lama
+			s.data.LastEventID = events.LastID()
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+	// Event log yang direset memulai ID dari 1 lagi; tanpa ini semua event
+	// baru dianggap sudah terkirim
+	if last := events.LastID(); s.data.LastEventID > last {
+		s.data.LastEventID = last
+	}
+	return s, nil
+}
+
+func (s *webhookStore) save() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+func (s *webhookStore) findHook(id string) int {
+	for i := range s.data.Webhooks {
+		if s.data.Webhooks[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *webhookStore) findDelivery(id string) int {
+	for i := range s.data.Deliveries {
+		if s.data.Deliveries[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create mendaftarkan webhook dengan secret baru. Webhook mulai menerima
+// event yang terjadi setelah dibuat.
+func (s *webhookStore) Create(target string, types []string) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook := Webhook{
+		ID:        randomID(4),
+		URL:       target,
+		Secret:    "whsec_" + randomID(16),
+		Events:    types,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	old := s.data
+	s.data.Webhooks = append(append([]Webhook{}, s.data.Webhooks...), hook)
+	if last := events.LastID(); last > s.data.LastEventID {
+		s.data.LastEventID = last
+	}
+	if err := s.save(); err != nil {
+		s.data = old
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+// Delete menghapus webhook beserta antrian pengirimannya.
+func (s *webhookStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findHook(id)
+	if i < 0 {
+		return false, nil
+	}
+	old := s.data
+	s.data.Webhooks = append(append([]Webhook{}, old.Webhooks[:i]...), old.Webhooks[i+1:]...)
+	s.data.Deliveries = []WebhookDelivery{}
+	for _, d := range old.Deliveries {
+		if d.WebhookID != id {
+			s.data.Deliveries = append(s.data.Deliveries, d)
+		}
+	}
+	if err := s.save(); err != nil {
+		s.data = old
+		return true, err
+	}
+	return true, nil
+}
+
+// List mengembalikan webhook tanpa secret.
+func (s *webhookStore) List() []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Webhook, len(s.data.Webhooks))
+	for i, h := range s.data.Webhooks {
+		h.Secret = ""
+		list[i] = h
+	}
+	return list
+}
+
+// Deliveries mengembalikan pengiriman (terbaru dulu) yang cocok dengan
+// webhookID dan status; string kosong berarti semua.
+func (s *webhookStore) Deliveries(webhookID, status string) []WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []WebhookDelivery{}
+	for i := len(s.data.Deliveries) - 1; i >= 0; i-- {
+		d := s.data.Deliveries[i]
+		if (webhookID == "" || d.WebhookID == webhookID) && (status == "" || d.Status == status) {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// Redeliver mengembalikan pengiriman (biasanya dari dead-letter) ke antrian.
+func (s *webhookStore) Redeliver(id string) (WebhookDelivery, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findDelivery(id)
+	if i < 0 {
+		return WebhookDelivery{}, false, nil
+	}
+	old := s.data.Deliveries[i]
+	d := &s.data.Deliveries[i]
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.NextAttempt = time.Now().Format(time.RFC3339)
+	d.UpdatedAt = d.NextAttempt
+	if err := s.save(); err != nil {
+		s.data.Deliveries[i] = old
+		return old, true, err
+	}
+	s.notify()
+	return *d, true, nil
+}
+
+func (s *webhookStore) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue membuat pengiriman untuk setiap webhook yang berlangganan tipe
+// event e. Event yang sudah pernah diantrekan diabaikan.
+func (s *webhookStore) Enqueue(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.ID <= s.data.LastEventID {
+		return
+	}
+	s.data.LastEventID = e.ID
+	now := time.Now().Format(time.RFC3339)
+	added := 0
+	for _, h := range s.data.Webhooks {
+		if !webhookWants(h, e.Type) {
+			continue
+		}
+		s.data.Deliveries = append(s.data.Deliveries, WebhookDelivery{
+			ID:          randomID(8),
+			WebhookID:   h.ID,
+			Event:       e,
+			Status:      DeliveryPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		added++
+	}
+	// Tanpa webhook tidak ada yang perlu disimpan; LastEventID cukup di memori
+	if added == 0 {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Gagal menyimpan antrian webhook: %v", err)
+	}
+	s.notify()
+}
+
+func webhookWants(h Webhook, eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Run mengikuti event bus dan mengirim antrian webhook. Dipanggil sekali
+// sebagai goroutine dari main.
+func (s *webhookStore) Run() {
+	go s.follow()
+	ticker := time.NewTicker(WebhookTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		s.deliverDue()
+	}
+}
+
+// follow memasukkan event ke antrian. Subscribe dilakukan sebelum membaca
+// event yang tertinggal agar tidak ada celah; duplikat diabaikan Enqueue.
+func (s *webhookStore) follow() {
+	for {
+		ch, cancel := events.Subscribe()
+		for {
+			s.mu.Lock()
+			if last := events.LastID(); s.data.LastEventID > last {
+				s.data.LastEventID = last
+			}
+			after := s.data.LastEventID
+			s.mu.Unlock()
+			list, _ := events.After(after, 500)
+			for _, e := range list {
+				s.Enqueue(e)
+			}
+			if len(list) < 500 {
+				break
+			}
+		}
+		for e := range ch {
+			s.Enqueue(e)
+		}
+		// Channel ditutup karena tertinggal: baca ulang dari LastEventID
+		cancel()
+	}
+}
+
+// deliverDue mengirim semua pengiriman pending yang sudah waktunya.
+// Request HTTP dijalankan tanpa memegang lock.
+func (s *webhookStore) deliverDue() {
+	now := time.Now()
+	type job struct {
+		delivery WebhookDelivery
+		hook     Webhook
+	}
+	var jobs []job
+	s.mu.Lock()
+	for _, d := range s.data.Deliveries {
+		if d.Status != DeliveryPending {
+			continue
+		}
+		if next, err := time.Parse(time.RFC3339, d.NextAttempt); err == nil && now.Before(next) {
+			continue
+		}
+		if i := s.findHook(d.WebhookID); i >= 0 {
+			jobs = append(jobs, job{d, s.data.Webhooks[i]})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, j := range jobs {
+		status, err := s.send(j.hook, j.delivery)
+		s.finish(j.delivery.ID, status, err)
+	}
+}
+
+// send mengirim event ke webhook. Signature adalah HMAC-SHA256 dari
+// "<timestamp>.<body>" dengan secret webhook.
+func (s *webhookStore) send(h Webhook, d WebhookDelivery) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "zivpn-webhook")
+	req.Header.Set("X-Zivpn-Event", d.Event.Type)
+	req.Header.Set("X-Zivpn-Delivery", d.ID)
+	req.Header.Set("X-Zivpn-Timestamp", ts)
+	req.Header.Set("X-Zivpn-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// finish mencatat hasil pengiriman dan menjadwalkan ulang dengan backoff
+// eksponensial, atau memindahkannya ke dead-letter setelah
+// WebhookMaxAttempts percobaan.
+func (s *webhookStore) finish(id string, status int, sendErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findDelivery(id)
+	if i < 0 {
+		// Webhook dihapus saat pengiriman berjalan
+		return
+	}
+	now := time.Now()
+	d := &s.data.Deliveries[i]
+	d.Attempts++
+	d.LastStatus = status
+	d.UpdatedAt = now.Format(time.RFC3339)
+	switch {
+	case sendErr == nil:
+		d.Status = DeliveryDelivered
+		d.NextAttempt = ""
+		d.LastError = ""
+	case d.Attempts >= WebhookMaxAttempts:
+		d.Status = DeliveryDead
+		d.NextAttempt = ""
+		d.LastError = sendErr.Error()
+		log.Printf("Webhook %s: event %d masuk dead-letter setelah %d percobaan: %v", d.WebhookID, d.Event.ID, d.Attempts, sendErr)
+	default:
+		delay := WebhookBaseDelay << uint(d.Attempts-1)
+		if delay > WebhookMaxDelay {
+			delay = WebhookMaxDelay
+		}
+		d.NextAttempt = now.Add(delay).Format(time.RFC3339)
+		d.LastError = sendErr.Error()
+	}
+	s.prune()
+	if err := s.save(); err != nil {
+		log.Printf("Gagal menyimpan status webhook: %v", err)
+	}
+}
+
+// prune membatasi riwayat ke WebhookHistory pengiriman selesai. Yang
+// terkirim dibuang lebih dulu; dead-letter hanya dibuang jika masih lebih.
+func (s *webhookStore) prune() {
+	done := 0
+	for _, d := range s.data.Deliveries {
+		if d.Status != DeliveryPending {
+			done++
+		}
+	}
+	for _, status := range []string{DeliveryDelivered, DeliveryDead} {
+		if done <= WebhookHistory {
+			return
+		}
+		kept := s.data.Deliveries[:0]
+		for _, d := range s.data.Deliveries {
+			if done > WebhookHistory && d.Status == status {
+				done--
+				continue
+			}
+			kept = append(kept, d)
+		}
+		s.data.Deliveries = kept
+	}
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Daftar webhook", webhooks.List())
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		jsonResponse(w, http.StatusBadRequest, false, "URL webhook harus http:// atau https://", nil)
+		return
+	}
+
+	hook, err := webhooks.Create(u.String(), req.Events)
+	if err != nil {
+		log.Printf("Gagal menyimpan webhook %s: %v", u, err)
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan webhook", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Webhook berhasil dibuat, simpan secret ini karena tidak akan ditampilkan lagi", hook)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	found, err := webhooks.Delete(req.ID)
+	if !found {
+		jsonResponse(w, http.StatusNotFound, false, "Webhook tidak ditemukan", nil)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan data webhook", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Webhook berhasil dihapus", nil)
+}
+
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	d, found, err := webhooks.Redeliver(req.DeliveryID)
+	if !found {
+		jsonResponse(w, http.StatusNotFound, false, "Pengiriman tidak ditemukan", nil)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan data webhook", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Pengiriman dijadwalkan ulang", d)
+}
+
+// listDeadLetters mengembalikan pengiriman yang gagal permanen dari semua
+// webhook.
+func listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Dead-letter webhook", webhooks.Deliveries("", DeliveryDead))
+}
+
+// webhookRoutes menangani path dengan ID webhook:
+// GET /api/webhooks/{id}/deliveries?status=pending|delivered|dead
+func webhookRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/webhooks/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "deliveries" {
+		jsonResponse(w, http.StatusNotFound, false, "Endpoint tidak ditemukan", nil)
+		return
+	}
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	webhooks.mu.Lock()
+	found := webhooks.findHook(parts[0]) >= 0
+	webhooks.mu.Unlock()
+	if !found {
+		jsonResponse(w, http.StatusNotFound, false, "Webhook tidak ditemukan", nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, true, "Riwayat pengiriman webhook", webhooks.Deliveries(parts[0], r.URL.Query().Get("status")))
+}
+
+// --- Metrics ---
+
+// latencyBuckets adalah batas atas bucket histogram latency (detik).
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabel struct {
+	path   string
+	method string
+	code   int
+}
+
+type latencyHistogram struct {
+	counts []int64 // per bucket, tidak kumulatif
+	sum    float64
+	count  int64
+}
+
+// apiMetrics menyimpan counter yang diekspos di /metrics dalam format
+// teks Prometheus. Jumlah user dan trafik dihitung saat scrape.
+type apiMetrics struct {
+	mu              sync.Mutex
+	started         time.Time
+	requests        map[requestLabel]int64
+	latency         map[string]*latencyHistogram
+	restarts        int64
+	restartFailures int64
+	expiryRuns      int64
+	expiryFailures  int64
+	expiredUsers    int64
+	purgedUsers     int64
+}
+
+var metrics = &apiMetrics{
+	started:  time.Now(),
+	requests: make(map[requestLabel]int64),
+	latency:  make(map[string]*latencyHistogram),
+}
+
+func (m *apiMetrics) ObserveRequest(path, method string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabel{path, method, code}]++
+	h := m.latency[path]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]int64, len(latencyBuckets))}
+		m.latency[path] = h
+	}
+	sec := d.Seconds()
+	for i, b := range latencyBuckets {
+		if sec <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+func (m *apiMetrics) Restart(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarts++
+	if err != nil {
+		m.restartFailures++
+	}
+}
+
+func (m *apiMetrics) ExpiryRun(purged, disabled int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expiryRuns++
+	if err != nil {
+		m.expiryFailures++
+	}
+	m.purgedUsers += int64(purged)
+	m.expiredUsers += int64(disabled)
+}
+
+// instrumented mencatat jumlah request dan latency per pola route mux.
+// Path yang tidak terdaftar digabung sebagai "other" agar label tidak
+// bertambah tanpa batas.
+func instrumented(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" || pattern == "/" {
+			pattern = "other"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		metrics.ObserveRequest(pattern, r.Method, rec.status, time.Since(start))
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter menulis metric dalam format teks Prometheus.
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&mw.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample menulis satu nilai; labels berisi pasangan nama, nilai.
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.buf.WriteString(name)
+	if len(labels) > 0 {
+		mw.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&mw.buf, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		mw.buf.WriteByte('}')
+	}
+	mw.buf.WriteByte(' ')
+	mw.buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	mw.buf.WriteByte('\n')
+}
+
+// serveMetrics menampilkan metric API dan status VPN untuk Prometheus.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	mutex.Lock()
+	users, err := store.Load()
+	mutex.Unlock()
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, false, "Gagal membaca database user", nil)
+		return
+	}
+
+	// Reseller hanya melihat user miliknya sendiri
+	key := requestKey(r)
+	now := time.Now()
+	byStatus := map[string]int{"active": 0, "expired": 0, "suspended": 0}
+	var owned []UserRecord
+	for _, u := range users {
+		if !ownsUser(key, u) {
+			continue
+		}
+		owned = append(owned, u)
+		byStatus[strings.ToLower(userStatus(u, now))]++
+	}
+
+	var mw metricsWriter
+	mw.header("zivpn_users", "gauge", "Jumlah user per status.")
+	for _, st := range []string{"active", "expired", "suspended"} {
+		mw.sample("zivpn_users", float64(byStatus[st]), "status", st)
+	}
+	mw.header("zivpn_user_traffic_bytes", "gauge", "Pemakaian trafik user sejak renew terakhir.")
+	for _, u := range owned {
+		mw.sample("zivpn_user_traffic_bytes", float64(u.UsageBytes+accounting.Pending(u.Username)), "username", u.Username)
+	}
+
+	metrics.mu.Lock()
+	mw.header("zivpn_api_start_time_seconds", "gauge", "Waktu start API (unix).")
+	mw.sample("zivpn_api_start_time_seconds", float64(metrics.started.Unix()))
+
+	mw.header("zivpn_api_requests_total", "counter", "Jumlah request API per endpoint, method dan status.")
+	labels := make([]requestLabel, 0, len(metrics.requests))
+	for l := range metrics.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	for _, l := range labels {
+		mw.sample("zivpn_api_requests_total", float64(metrics.requests[l]), "path", l.path, "method", l.method, "code", strconv.Itoa(l.code))
+	}
+
+	mw.header("zivpn_api_request_duration_seconds", "histogram", "Latency request API per endpoint.")
+	paths := make([]string, 0, len(metrics.latency))
+	for p := range metrics.latency {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		h := metrics.latency[p]
+		var cumulative int64
+		for i, b := range latencyBuckets {
+			cumulative += h.counts[i]
+			mw.sample("zivpn_api_request_duration_seconds_bucket", float64(cumulative), "path", p, "le", strconv.FormatFloat(b, 'g', -1, 64))
+		}
+		mw.sample("zivpn_api_request_duration_seconds_bucket", float64(h.count), "path", p, "le", "+Inf")
+		mw.sample("zivpn_api_request_duration_seconds_sum", h.sum, "path", p)
+		mw.sample("zivpn_api_request_duration_seconds_count", float64(h.count), "path", p)
+	}
+
+	mw.header("zivpn_service_restarts_total", "counter", "Jumlah restart service zivpn.")
+	mw.sample("zivpn_service_restarts_total", float64(metrics.restarts))
+	mw.header("zivpn_service_restart_failures_total", "counter", "Jumlah restart service zivpn yang gagal.")
+	mw.sample("zivpn_service_restart_failures_total", float64(metrics.restartFailures))
+	mw.header("zivpn_expiry_runs_total", "counter", "Jumlah pengecekan user expired.")
+	mw.sample("zivpn_expiry_runs_total", float64(metrics.expiryRuns))
+	mw.header("zivpn_expiry_run_failures_total", "counter", "Jumlah pengecekan user expired yang gagal.")
+	mw.sample("zivpn_expiry_run_failures_total", float64(metrics.expiryFailures))
+	mw.header("zivpn_expiry_disabled_users_total", "counter", "Jumlah user yang dinonaktifkan karena expired.")
+	mw.sample("zivpn_expiry_disabled_users_total", float64(metrics.expiredUsers))
+	mw.header("zivpn_expiry_purged_users_total", "counter", "Jumlah user expired yang dihapus otomatis.")
+	mw.sample("zivpn_expiry_purged_users_total", float64(metrics.purgedUsers))
+	metrics.mu.Unlock()
+
+	if key.Reseller == "" {
+		mw.header("zivpn_events_last_id", "gauge", "ID event terakhir.")
+		mw.sample("zivpn_events_last_id", float64(events.LastID()))
+		mw.header("zivpn_webhook_deliveries", "gauge", "Jumlah pengiriman webhook per status.")
+		for _, st := range []string{DeliveryPending, DeliveryDelivered, DeliveryDead} {
+			mw.sample("zivpn_webhook_deliveries", float64(len(webhooks.Deliveries("", st))), "status", st)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(mw.buf.Bytes())
+}
+
+// --- Health Check ---
+
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type HealthReport struct {
+	Status string        `json:"status"` // ok, degraded (ada warn) atau fail
+	Time   string        `json:"time"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// runHealthChecks memeriksa service core, config.json, port UDP,
+// sertifikat TLS dan database user.
+func runHealthChecks() HealthReport {
+	var checks []HealthCheck
+	add := func(name, status, format string, args ...interface{}) {
+		checks = append(checks, HealthCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+	}
+
+	out, _ := runner.Run("systemctl", "is-active", "zivpn.service")
+	if state := strings.TrimSpace(string(out)); state == "active" {
+		add("service", HealthOK, "zivpn.service aktif")
+	} else {
+		add("service", HealthFail, "zivpn.service tidak aktif (%s)", state)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		add("config", HealthFail, "config.json tidak bisa dibaca: %v", err)
+	} else {
+		add("config", HealthOK, "config.json valid")
+
+		port := corePort()
+		if bound, err := udpPortBound(port); err != nil {
+			add("listen_port", HealthWarn, "tidak bisa memeriksa port UDP %s: %v", port, err)
+		} else if bound {
+			add("listen_port", HealthOK, "port UDP %s terbuka", port)
+		} else {
+			add("listen_port", HealthFail, "port UDP %s tidak terbuka", port)
+		}
+
+		checks = append(checks, checkCertificate(config.Cert))
+		if _, err := os.Stat(config.Key); err != nil {
+			add("key", HealthFail, "key %s tidak ditemukan", config.Key)
+		} else {
+			add("key", HealthOK, "key %s ada", config.Key)
+		}
+	}
+
+	if err := checkStoreWritable(); err != nil {
+		add("user_store", HealthFail, "database user tidak bisa ditulis: %v", err)
+	} else {
+		add("user_store", HealthOK, "database user bisa dibaca dan ditulis")
+	}
+
+	report := HealthReport{Status: HealthOK, Time: time.Now().Format(time.RFC3339), Checks: checks}
+	for _, c := range checks {
+		if c.Status == HealthFail {
+			report.Status = HealthFail
+			break
+		}
+		if c.Status == HealthWarn {
+			report.Status = "degraded"
+		}
+	}
+	return report
+}
+
+// udpPortBound mencari socket UDP (IPv4/IPv6) yang listen di port.
+func udpPortBound(port string) (bool, error) {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false, err
+	}
+	want := fmt.Sprintf(":%04X", n)
+	read := 0
+	for _, path := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		read++
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) > 1 && strings.HasSuffix(fields[1], want) {
+				return true, nil
+			}
+		}
+	}
+	if read == 0 {
+		return false, fmt.Errorf("/proc/net/udp tidak tersedia")
+	}
+	return false, nil
+}
+
+func checkCertificate(path string) HealthCheck {
+	check := HealthCheck{Name: "certificate", Status: HealthFail}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		check.Message = fmt.Sprintf("sertifikat %s tidak ditemukan", path)
+		return check
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		check.Message = fmt.Sprintf("sertifikat %s bukan PEM", path)
+		return check
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		check.Message = fmt.Sprintf("sertifikat %s tidak valid: %v", path, err)
+		return check
+	}
+	left := time.Until(cert.NotAfter)
+	until := cert.NotAfter.In(displayLoc).Format("2006-01-02")
+	switch {
+	case left <= 0:
+		check.Message = "sertifikat sudah habis sejak " + until
+	case left < HealthCertWarnDays*24*time.Hour:
+		check.Status = HealthWarn
+		check.Message = "sertifikat habis pada " + until
+	default:
+		check.Status = HealthOK
+		check.Message = "sertifikat berlaku sampai " + until
+	}
+	return check
+}
+
+// checkStoreWritable membaca database user lalu memastikan backend bisa
+// menulis tanpa mengubah data.
+func checkStoreWritable() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, err := store.Load(); err != nil {
+		return err
+	}
+	switch st := store.(type) {
+	case *boltStore:
+		return st.db.Update(func(tx *bolt.Tx) error { return nil })
+	case *fileStore:
+		// writeFileAtomic menulis file sementara di direktori yang sama
+		tmp, err := ioutil.TempFile(filepath.Dir(st.path), ".health-")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+	return nil
+}
+
+func healthStatusCode(report HealthReport) int {
+	if report.Status == HealthFail {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+var healthzCache struct {
+	mu     sync.Mutex
+	report HealthReport
+	at     time.Time
+}
+
+// cachedHealthChecks mengembalikan hasil runHealthChecks yang berumur
+// kurang dari HealthzCacheTTL. Request bersamaan menunggu satu pengecekan.
+func cachedHealthChecks() HealthReport {
+	healthzCache.mu.Lock()
+	defer healthzCache.mu.Unlock()
+	if healthzCache.at.IsZero() || time.Since(healthzCache.at) >= HealthzCacheTTL {
+		healthzCache.report = runHealthChecks()
+		healthzCache.at = time.Now()
+	}
+	return healthzCache.report
+}
+
+// healthz tidak butuh API key sehingga detail pesan tidak ditampilkan.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	report := cachedHealthChecks()
+	checks := make(map[string]string, len(report.Checks))
+	for _, c := range report.Checks {
+		checks[c.Name] = c.Status
+	}
+	jsonResponse(w, healthStatusCode(report), report.Status != HealthFail, report.Status, checks)
+}
+
+func healthReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+	report := runHealthChecks()
+	jsonResponse(w, healthStatusCode(report), report.Status != HealthFail, "Health check", report)
+}
+
+// --- Restart Core ---
+
+// ServiceStatus adalah status reload/restart core terakhir.
+type ServiceStatus struct {
+	Pending     bool   `json:"pending"`
+	Running     bool   `json:"running"`
+	LastRequest string `json:"last_request,omitempty"`
+	LastRun     string `json:"last_run,omitempty"`
+	LastMethod  string `json:"last_method,omitempty"` // reload atau restart
+	LastSuccess string `json:"last_success,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	Runs        int64  `json:"runs"`
+	Failures    int64  `json:"failures"`
+	// Coalesced adalah jumlah permintaan yang digabung ke eksekusi lain.
+	Coalesced int64 `json:"coalesced"`
+}
+
+// restartCoordinator menjalankan reload/restart core di belakang handler
+// sehingga request tidak menunggu systemctl dan tidak memegang mutex.
+type restartCoordinator struct {
+	mu     sync.Mutex
+	status ServiceStatus
+	hard   bool // ada permintaan yang butuh restart penuh
+	wake   chan struct{}
+}
+
+var restarts = &restartCoordinator{wake: make(chan struct{}, 1)}
+
+// Request menjadwalkan reload core. hard memaksa restart penuh, misalnya
+// untuk memutus sesi user yang di-suspend atau mengganti mode auth.
+func (c *restartCoordinator) Request(hard bool) {
+	c.mu.Lock()
+	if c.status.Pending {
+		c.status.Coalesced++
+	}
+	c.status.Pending = true
+	c.status.LastRequest = time.Now().Format(time.RFC3339)
+	c.hard = c.hard || hard
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *restartCoordinator) Status() ServiceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Run memproses permintaan. Dipanggil sekali sebagai goroutine dari main.
+func (c *restartCoordinator) Run() {
+	for range c.wake {
+		c.debounce()
+
+		c.mu.Lock()
+		hard := c.hard
+		c.hard = false
+		c.status.Pending = false
+		c.status.Running = true
+		c.mu.Unlock()
+
+		method, err := reloadCore(hard)
+		metrics.Restart(err)
+
+		now := time.Now().Format(time.RFC3339)
+		c.mu.Lock()
+		c.status.Running = false
+		c.status.Runs++
+		c.status.LastRun = now
+		c.status.LastMethod = method
+		if err != nil {
+			c.status.Failures++
+			c.status.LastError = err.Error()
+		} else {
+			c.status.LastSuccess = now
+			c.status.LastError = ""
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Gagal %s service zivpn: %v", method, err)
+			events.Publish(Event{Type: EventServiceRestartFailed, Data: map[string]interface{}{"method": method, "error": err.Error()}})
+		} else {
+			events.Publish(Event{Type: EventServiceRestarted, Data: map[string]interface{}{"method": method}})
+		}
 	}
+}
 
-	jsonResponse(w, http.StatusOK, true, "System Info", info)
+// debounce menunggu sampai tidak ada permintaan baru selama
+// RestartDebounce, paling lama RestartMaxDelay.
+func (c *restartCoordinator) debounce() {
+	deadline := time.After(RestartMaxDelay)
+	quiet := time.NewTimer(RestartDebounce)
+	defer quiet.Stop()
+	for {
+		select {
+		case <-c.wake:
+			if !quiet.Stop() {
+				<-quiet.C
+			}
+			quiet.Reset(RestartDebounce)
+		case <-quiet.C:
+			return
+		case <-deadline:
+			return
+		}
+	}
 }
 
-// --- Helper Functions ---
+// reloadCore memakai "systemctl reload" jika unit core mendukungnya dan
+// restart penuh tidak diminta. Jika reload gagal, core di-restart.
+func reloadCore(hard bool) (string, error) {
+	if !hard {
+		out, _ := runner.Run("systemctl", "show", "-p", "CanReload", "--value", "zivpn.service")
+		if strings.TrimSpace(string(out)) == "yes" {
+			_, err := runner.Run("systemctl", "reload", "zivpn.service")
+			if err == nil {
+				return "reload", nil
+			}
+			log.Printf("Reload service zivpn gagal, mencoba restart: %v", err)
+		}
+	}
+	_, err := runner.Run("systemctl", "restart", "zivpn.service")
+	return "restart", err
+}
 
-func loadConfig() (Config, error) {
-	var config Config
-	file, err := ioutil.ReadFile(ConfigFile)
-	if err != nil {
-		return config, err
+func serviceStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
 	}
-	err = json.Unmarshal(file, &config)
-	return config, err
+	out, _ := runner.Run("systemctl", "is-active", "zivpn.service")
+	jsonResponse(w, http.StatusOK, true, "Status service", map[string]interface{}{
+		"active":  strings.TrimSpace(string(out)),
+		"restart": restarts.Status(),
+	})
 }
 
-func saveConfig(config Config) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+// --- Transaksi User ---
+
+// opError adalah kegagalan satu operasi user beserta status HTTP dan
+// kode error-nya.
+type opError struct {
+	status  int
+	code    string
+	message string
+}
+
+func opFail(status int, code, message string) *opError {
+	return &opError{status: status, code: code, message: message}
+}
+
+func opResponse(w http.ResponseWriter, oerr *opError) {
+	errorResponse(w, oerr.status, oerr.code, oerr.message)
+}
+
+// userTx mengumpulkan perubahan beberapa operasi user di memori sehingga
+// config.json dan database user ditulis sekali. Pemanggil memegang mutex
+// dari beginUserTx sampai commit atau rollback.
+type userTx struct {
+	key    ApiKey
+	config Config
+	users  []UserRecord
+	debit  int64    // saldo reseller yang sudah dipotong
+	events []Event  // dipublikasikan setelah commit
+	resets []string // counter trafik yang direset setelah commit
+
+	reload     bool // daftar password berubah, core perlu reload
+	disconnect bool // sesi aktif harus diputus dengan restart penuh
+}
+
+func beginUserTx(key ApiKey) (*userTx, *opError) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, opFail(http.StatusInternalServerError, ErrInternal, "Gagal membaca config")
+	}
+	users, err := store.Load()
 	if err != nil {
+		return nil, opFail(http.StatusInternalServerError, ErrInternal, "Gagal membaca database user")
+	}
+	return &userTx{key: key, config: config, users: users}, nil
+}
+
+// commit menyimpan semua perubahan lalu menjadwalkan paling banyak satu
+// reload core. Jika penyimpanan gagal, saldo reseller dikembalikan.
+func (tx *userTx) commit() error {
+	if err := saveConfigAndUsers(tx.config, tx.users); err != nil {
+		tx.rollback()
 		return err
 	}
-	return ioutil.WriteFile(ConfigFile, data, 0644)
+	for _, username := range tx.resets {
+		accounting.Reset(username)
+	}
+	for _, e := range tx.events {
+		events.Publish(e)
+	}
+	if tx.disconnect {
+		// Restart memutus sesi yang sedang berjalan
+		restarts.Request(true)
+	} else if tx.reload {
+		applyUserChange()
+	}
+	return nil
+}
+
+// dropSessions dipanggil saat password berhenti berlaku (delete, ganti
+// password, suspend). Pada mode passwords sesi lama hanya terputus lewat
+// restart penuh. Pada mode external callback auth sudah menolak user itu,
+// jadi core tidak di-restart agar client lain tidak ikut terputus.
+func (tx *userTx) dropSessions() {
+	if tx.config.Auth.Mode == AuthModeExternal {
+		tx.reload = true
+		return
+	}
+	tx.disconnect = true
+}
+
+// rollback mengembalikan saldo reseller; perubahan di memori dibuang.
+func (tx *userTx) rollback() {
+	refundReseller(tx.key.Reseller, tx.debit)
+	tx.debit = 0
+}
+
+// charge memotong saldo reseller untuk durasi d. Dipanggil terakhir
+// sebelum operasi mengubah data agar operasi yang gagal tidak tertagih.
+func (tx *userTx) charge(d time.Duration) (int64, *opError) {
+	if tx.key.Reseller == "" {
+		return 0, nil
+	}
+	rs, ok := resellers.Get(tx.key.Reseller)
+	if !ok {
+		return 0, opFail(http.StatusForbidden, ErrForbidden, "Reseller tidak ditemukan")
+	}
+	cost := rs.PricePerDay * int64(billableDays(d))
+	if err := resellers.Debit(rs.ID, cost); err != nil {
+		return 0, opFail(http.StatusPaymentRequired, ErrInsufficientBalance, err.Error())
+	}
+	tx.debit += cost
+	return cost, nil
 }
 
-func loadUsers() ([]string, error) {
-	file, err := ioutil.ReadFile(UserDB)
+// requestDuration membaca duration ("30d", "12h") atau days dari request.
+// Durasi nol atau negatif ditolak agar tidak membuat user yang langsung
+// expired atau memendekkan expired lewat renew.
+func requestDuration(req UserRequest) (time.Duration, *opError) {
+	durStr := strings.TrimSpace(req.Duration)
+	if durStr == "" {
+		if req.Days <= 0 {
+			return 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Days/duration harus lebih dari 0")
+		}
+		return time.Duration(req.Days) * 24 * time.Hour, nil
+	}
+	d, err := parseSpan(durStr)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+		return 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Format duration tidak valid")
+	}
+	if d <= 0 {
+		return 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Days/duration harus lebih dari 0")
+	}
+	return d, nil
+}
+
+func (tx *userTx) create(req UserRequest) (UserRecord, int64, *opError) {
+	if req.Password == "" || (req.Days <= 0 && strings.TrimSpace(req.Duration) == "") {
+		return UserRecord{}, 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Password dan days/duration harus valid")
+	}
+	if strings.Contains(req.Password, "|") {
+		return UserRecord{}, 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Password tidak boleh mengandung karakter |")
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username != "" && !validUsername(req.Username) {
+		return UserRecord{}, 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Username harus 3-32 karakter: huruf, angka, titik, strip atau underscore")
+	}
+	if !validLimits(req) {
+		return UserRecord{}, 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Limit IP dan limit kuota tidak boleh negatif")
+	}
+	dur, oerr := requestDuration(req)
+	if oerr != nil {
+		return UserRecord{}, 0, oerr
+	}
+
+	now := time.Now()
+	expiry := now.Add(dur)
+	record := UserRecord{Username: req.Username, Password: req.Password, Expired: formatExpiry(expiry), Status: StatusActive, Owner: tx.key.Reseller, CreatedAt: formatExpiry(now)}
+	applyLimits(&record, req)
+	applyProfile(&record, req)
+
+	if findPassword(tx.config.Auth.Config, req.Password) >= 0 || findUser(tx.users, req.Password) >= 0 {
+		return UserRecord{}, 0, opFail(http.StatusConflict, ErrUserExists, "User sudah ada")
+	}
+	if record.Username == "" {
+		record.Username = generateUsername(tx.users)
+	} else if findUsername(tx.users, record.Username) >= 0 {
+		return UserRecord{}, 0, opFail(http.StatusConflict, ErrUsernameTaken, "Username sudah dipakai")
+	}
+
+	if tx.key.Reseller != "" {
+		if rs, ok := resellers.Get(tx.key.Reseller); ok && rs.MaxAccounts > 0 && countOwned(tx.users, rs.ID) >= rs.MaxAccounts {
+			return UserRecord{}, 0, opFail(http.StatusForbidden, ErrAccountLimit, fmt.Sprintf("Batas maksimal %d akun reseller tercapai", rs.MaxAccounts))
 		}
-		return nil, err
 	}
-	lines := strings.Split(string(file), "\n")
-	var result []string
-	for _, line := range lines {
-		if strings.TrimSpace(line) != "" {
-			result = append(result, line)
+	cost, oerr := tx.charge(time.Until(expiry))
+	if oerr != nil {
+		return UserRecord{}, 0, oerr
+	}
+
+	tx.config.Auth.Config = append(tx.config.Auth.Config, req.Password)
+	tx.users = append(tx.users, record)
+	tx.reload = true
+	tx.events = append(tx.events, Event{Type: EventUserCreated, Username: record.Username, Owner: record.Owner, Data: map[string]interface{}{"expired": record.Expired}})
+	return record, cost, nil
+}
+
+// delete menghapus user. Password yang hanya ada di config (tanpa record)
+// tetap bisa dihapus admin dengan mengirim password saja.
+func (tx *userTx) delete(req UserRequest) (UserRecord, *opError) {
+	password := req.Password
+	idx := lookupOwnedUser(tx.users, tx.key, req.Username, req.Password)
+	var deleted UserRecord
+	if idx >= 0 {
+		deleted = tx.users[idx]
+		password = deleted.Password
+		tx.users = append(append([]UserRecord{}, tx.users[:idx]...), tx.users[idx+1:]...)
+		tx.events = append(tx.events, Event{Type: EventUserDeleted, Username: deleted.Username, Owner: deleted.Owner})
+	} else if req.Username != "" || tx.key.Reseller != "" || password == "" || findPassword(tx.config.Auth.Config, password) < 0 {
+		return UserRecord{}, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+	}
+	tx.config.Auth.Config = removePassword(tx.config.Auth.Config, password)
+	tx.dropSessions()
+	return deleted, nil
+}
+
+func (tx *userTx) renew(req UserRequest) (UserRecord, int64, *opError) {
+	if !validLimits(req) {
+		return UserRecord{}, 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Limit IP dan limit kuota tidak boleh negatif")
+	}
+	addDur, oerr := requestDuration(req)
+	if oerr != nil {
+		return UserRecord{}, 0, oerr
+	}
+	// Renew tanpa durasi akan mereset kuota dan mengaktifkan user gratis
+	if addDur <= 0 {
+		return UserRecord{}, 0, opFail(http.StatusBadRequest, ErrInvalidRequest, "Days/duration renew harus lebih dari 0")
+	}
+	idx := lookupOwnedUser(tx.users, tx.key, req.Username, req.Password)
+	if idx < 0 {
+		return UserRecord{}, 0, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan di database")
+	}
+	cost, oerr := tx.charge(addDur)
+	if oerr != nil {
+		return UserRecord{}, 0, oerr
+	}
+
+	u := tx.users[idx]
+	currentExp, err := parseExpiry(u.Expired)
+	// Jika format tanggal salah atau sudah expired, mulai dari sekarang.
+	// Jika belum, tambah dari waktu expired.
+	if err != nil || currentExp.Before(time.Now()) {
+		currentExp = time.Now()
+	}
+	u.Expired = formatExpiry(currentExp.Add(addDur))
+	// Limit dan profil hanya diubah jika dikirim di request
+	applyLimits(&u, req)
+	applyProfile(&u, req)
+	// Renew memulai periode baru: pemakaian kuota dihitung dari nol
+	u.UsageBytes = 0
+	tx.resets = append(tx.resets, u.Username)
+
+	// User yang di-suspend karena kuota habis atau expired (masa tenggang)
+	// otomatis aktif kembali
+	if u.Status == StatusSuspended && (u.Reason == ReasonQuota || u.Reason == ReasonExpired) {
+		u.Status = StatusActive
+		u.Reason = ""
+		tx.config.Auth.Config = addPassword(tx.config.Auth.Config, u.Password)
+	}
+	tx.users[idx] = u
+	tx.reload = true
+	tx.events = append(tx.events, Event{Type: EventUserRenewed, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"expired": u.Expired}})
+	return u, cost, nil
+}
+
+// rename mengganti password user. Username, expired, limit, pemakaian dan
+// status tetap sama; posisi password di config.json juga dipertahankan.
+func (tx *userTx) rename(req RenameRequest) (UserRecord, *opError) {
+	newPassword := strings.TrimSpace(req.NewPassword)
+	if (req.Username == "" && req.OldPassword == "") || newPassword == "" {
+		return UserRecord{}, opFail(http.StatusBadRequest, ErrInvalidRequest, "Username (atau password lama) dan password baru harus diisi")
+	}
+	if strings.Contains(newPassword, "|") {
+		return UserRecord{}, opFail(http.StatusBadRequest, ErrInvalidRequest, "Password tidak boleh mengandung karakter |")
+	}
+	idx := lookupOwnedUser(tx.users, tx.key, req.Username, req.OldPassword)
+	if idx < 0 {
+		return UserRecord{}, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+	}
+	oldPassword := tx.users[idx].Password
+	if oldPassword == newPassword {
+		return UserRecord{}, opFail(http.StatusBadRequest, ErrInvalidRequest, "Password baru sama dengan password lama")
+	}
+	if findUser(tx.users, newPassword) >= 0 || findPassword(tx.config.Auth.Config, newPassword) >= 0 {
+		return UserRecord{}, opFail(http.StatusConflict, ErrPasswordTaken, "Password baru sudah dipakai user lain")
+	}
+
+	tx.users[idx].Password = newPassword
+	for i, p := range tx.config.Auth.Config {
+		if p == oldPassword {
+			tx.config.Auth.Config[i] = newPassword
 		}
 	}
-	return result, nil
+	u := tx.users[idx]
+	tx.dropSessions()
+	tx.events = append(tx.events, Event{Type: EventPasswordChanged, Username: u.Username, Owner: u.Owner})
+	return u, nil
 }
 
-func saveUsers(lines []string) error {
-	data := strings.Join(lines, "\n") + "\n"
-	return ioutil.WriteFile(UserDB, []byte(data), 0644)
+// update mengubah profil dan limit user tanpa menyentuh expired maupun
+// pemakaian. Field yang tidak dikirim tidak diubah.
+func (tx *userTx) update(req UserRequest) (UserRecord, *opError) {
+	if !validLimits(req) {
+		return UserRecord{}, opFail(http.StatusBadRequest, ErrInvalidRequest, "Limit IP dan limit kuota tidak boleh negatif")
+	}
+	idx := lookupOwnedUser(tx.users, tx.key, req.Username, req.Password)
+	if idx < 0 {
+		return UserRecord{}, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+	}
+	applyProfile(&tx.users[idx], req)
+	applyLimits(&tx.users[idx], req)
+	return tx.users[idx], nil
+}
+
+func (tx *userTx) suspend(req UserRequest) (UserRecord, *opError) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = ReasonManual
+	}
+	// Alasan ini dipakai enforcer otomatis dan bisa diaktifkan kembali
+	// otomatis, jadi tidak boleh dipakai untuk suspend manual
+	switch strings.ToLower(reason) {
+	case ReasonQuota, ReasonLimitIP, ReasonExpired:
+		return UserRecord{}, opFail(http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("Alasan %q dipakai sistem, gunakan alasan lain", reason))
+	}
+	idx := lookupOwnedUser(tx.users, tx.key, req.Username, req.Password)
+	if idx < 0 {
+		return UserRecord{}, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+	}
+	if tx.users[idx].Status == StatusSuspended {
+		return UserRecord{}, opFail(http.StatusConflict, ErrAlreadySuspended, "User sudah di-suspend")
+	}
+
+	u := &tx.users[idx]
+	u.Status = StatusSuspended
+	u.Reason = reason
+	u.SuspendedUntil = ""
+	tx.config.Auth.Config = removePassword(tx.config.Auth.Config, u.Password)
+	tx.dropSessions()
+	tx.events = append(tx.events, Event{Type: EventUserSuspended, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"reason": reason}})
+	return *u, nil
+}
+
+func (tx *userTx) unsuspend(req UserRequest) (UserRecord, *opError) {
+	idx := lookupOwnedUser(tx.users, tx.key, req.Username, req.Password)
+	if idx < 0 {
+		return UserRecord{}, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+	}
+	if tx.users[idx].Status != StatusSuspended {
+		return UserRecord{}, opFail(http.StatusConflict, ErrNotSuspended, "User tidak dalam status suspend")
+	}
+
+	u := &tx.users[idx]
+	u.Status = StatusActive
+	u.Reason = ""
+	u.SuspendedUntil = ""
+	tx.config.Auth.Config = addPassword(tx.config.Auth.Config, u.Password)
+	tx.reload = true
+	tx.events = append(tx.events, Event{Type: EventUserUnsuspended, Username: u.Username, Owner: u.Owner})
+	return *u, nil
 }
 
-func restartService() error {
-	cmd := exec.Command("systemctl", "restart", "zivpn.service")
-	return cmd.Run()
+// BulkOperation adalah satu operasi di /api/users/bulk. Field lain sama
+// dengan body endpoint user tunggal.
+type BulkOperation struct {
+	Op string `json:"op"` // create, delete, renew atau restore
+	UserRequest
+	// Status dan Owner hanya dipakai restore: status "suspended" beserta
+	// Reason dan reseller pemilik (khusus admin) ikut dipulihkan.
+	Status string `json:"status"`
+	Owner  string `json:"owner"`
+}
+
+type BulkRequest struct {
+	// Atomic: jika satu operasi gagal, tidak ada perubahan yang disimpan.
+	Atomic     bool            `json:"atomic"`
+	Operations []BulkOperation `json:"operations"`
+}
+
+type BulkResult struct {
+	Index    int         `json:"index"`
+	Op       string      `json:"op"`
+	Username string      `json:"username,omitempty"`
+	Success  bool        `json:"success"`
+	Status   int         `json:"status"`
+	Code     string      `json:"code,omitempty"`
+	Message  string      `json:"message"`
+	Warning  string      `json:"warning,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// restore membuat user dari backup seperti create, lalu memulihkan status
+// suspend (dengan alasan apa adanya, termasuk alasan sistem) dan pemiliknya.
+// Jika reseller pemilik belum ada (misalnya restore ke server baru), user
+// tetap dibuat sebagai milik admin dan warning dikembalikan.
+func (tx *userTx) restore(op BulkOperation) (UserRecord, int64, string, *opError) {
+	status := strings.ToLower(strings.TrimSpace(op.Status))
+	if status != "" && status != StatusActive && status != StatusSuspended {
+		return UserRecord{}, 0, "", opFail(http.StatusBadRequest, ErrInvalidRequest, "Status harus active atau suspended")
+	}
+	var warning string
+	owner := strings.TrimSpace(op.Owner)
+	if owner != "" {
+		if tx.key.Reseller != "" {
+			return UserRecord{}, 0, "", opFail(http.StatusForbidden, ErrForbidden, "Owner hanya bisa diatur admin")
+		}
+		if _, ok := resellers.Get(owner); !ok {
+			warning = fmt.Sprintf("Reseller %s tidak ditemukan, user dipulihkan sebagai milik admin", owner)
+			owner = ""
+		}
+	}
+
+	record, cost, oerr := tx.create(op.UserRequest)
+	if oerr != nil {
+		return UserRecord{}, 0, "", oerr
+	}
+	u := &tx.users[len(tx.users)-1]
+	if owner != "" {
+		u.Owner = owner
+		tx.events[len(tx.events)-1].Owner = owner
+	}
+	if status == StatusSuspended {
+		reason := strings.TrimSpace(op.Reason)
+		if reason == "" {
+			reason = ReasonManual
+		}
+		u.Status = StatusSuspended
+		u.Reason = reason
+		tx.config.Auth.Config = removePassword(tx.config.Auth.Config, record.Password)
+		tx.events = append(tx.events, Event{Type: EventUserSuspended, Username: u.Username, Owner: u.Owner, Data: map[string]interface{}{"reason": reason}})
+	}
+	return *u, cost, warning, nil
+}
+
+// bulkUsers menjalankan banyak create/delete/renew dalam satu transaksi:
+// config.json dan database user ditulis sekali dan core di-reload sekali.
+func bulkUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		return
+	}
+
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > BulkMaxOperations {
+		jsonResponse(w, http.StatusBadRequest, false, fmt.Sprintf("Jumlah operasi harus 1-%d", BulkMaxOperations), nil)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	key := requestKey(r)
+	tx, oerr := beginUserTx(key)
+	if oerr != nil {
+		opResponse(w, oerr)
+		return
+	}
+
+	results := make([]BulkResult, len(req.Operations))
+	failed := 0
+	for i, op := range req.Operations {
+		res := BulkResult{Index: i, Op: op.Op, Username: op.Username, Status: http.StatusOK}
+		var u UserRecord
+		var cost int64
+		oerr = nil
+		switch op.Op {
+		case "create":
+			u, cost, oerr = tx.create(op.UserRequest)
+			res.Message = "User berhasil dibuat"
+		case "delete":
+			u, oerr = tx.delete(op.UserRequest)
+			res.Message = "User berhasil dihapus"
+		case "renew":
+			u, cost, oerr = tx.renew(op.UserRequest)
+			res.Message = "User berhasil diperpanjang"
+		case "restore":
+			u, cost, res.Warning, oerr = tx.restore(op)
+			res.Message = "User berhasil dipulihkan"
+		default:
+			oerr = opFail(http.StatusBadRequest, ErrInvalidRequest, "Operasi harus create, delete, renew atau restore")
+		}
+		if oerr != nil {
+			failed++
+			res.Status = oerr.status
+			res.Code = oerr.code
+			res.Message = oerr.message
+		} else {
+			res.Success = true
+			if u.Username != "" {
+				res.Username = u.Username
+			}
+			if op.Op != "delete" {
+				data := map[string]interface{}{"password": u.Password, "expired": u.Expired, "status": u.Status}
+				if key.Reseller != "" {
+					data["cost"] = cost
+				}
+				res.Data = data
+			}
+		}
+		results[i] = res
+	}
+
+	if req.Atomic && failed > 0 {
+		tx.rollback()
+		jsonResponse(w, http.StatusBadRequest, false, fmt.Sprintf("%d operasi gagal, tidak ada perubahan yang disimpan", failed), results)
+		return
+	}
+	if failed < len(results) {
+		if err := tx.commit(); err != nil {
+			log.Printf("Gagal menyimpan operasi bulk: %v", err)
+			jsonResponse(w, http.StatusInternalServerError, false, "Gagal menyimpan config dan database user", nil)
+			return
+		}
+	}
+	jsonResponse(w, http.StatusOK, failed == 0, fmt.Sprintf("%d berhasil, %d gagal", len(results)-failed, failed), results)
+}
+
+// --- API v2 ---
+//
+//	GET    /api/v2/users        daftar user, selalu berhalaman
+//	POST   /api/v2/users        buat user
+//	GET    /api/v2/users/{id}   detail user
+//	PATCH  /api/v2/users/{id}   ubah password, profil, limit, status atau perpanjang
+//	DELETE /api/v2/users/{id}   hapus user
+//
+// {id} adalah username. Endpoint v1 tetap tersedia dan memakai transaksi
+// yang sama, sehingga perilaku keduanya tidak berbeda.
+
+// UserPatch adalah body PATCH /api/v2/users/{id}. Field yang tidak dikirim
+// tidak diubah.
+type UserPatch struct {
+	Password    *string `json:"password"`
+	DisplayName *string `json:"display_name"`
+	Contact     *string `json:"contact"`
+	TelegramID  *int64  `json:"telegram_id"`
+	LimitIP     *int    `json:"limit_ip"`
+	LimitQuota  *int    `json:"limit_quota"`
+	// Extend memperpanjang expired seperti renew, contoh "30d" atau "12h".
+	Extend string `json:"extend"`
+	// Status "active" atau "suspended"; Reason dipakai saat suspend.
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// patch menerapkan UserPatch dalam satu transaksi. Status diproses
+// terakhir agar status yang diminta tidak tertimpa unsuspend otomatis
+// dari renew.
+func (tx *userTx) patch(username string, p UserPatch) (UserRecord, *opError) {
+	if p.Status != "" && p.Status != StatusActive && p.Status != StatusSuspended {
+		return UserRecord{}, opFail(http.StatusBadRequest, ErrInvalidRequest, "Status harus active atau suspended")
+	}
+	idx := lookupOwnedUser(tx.users, tx.key, username, "")
+	if idx < 0 {
+		return UserRecord{}, opFail(http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+	}
+
+	if p.Password != nil && strings.TrimSpace(*p.Password) != tx.users[idx].Password {
+		if _, oerr := tx.rename(RenameRequest{Username: username, NewPassword: *p.Password}); oerr != nil {
+			return UserRecord{}, oerr
+		}
+	}
+
+	req := UserRequest{
+		Username:    username,
+		DisplayName: p.DisplayName,
+		Contact:     p.Contact,
+		TelegramID:  p.TelegramID,
+		LimitIP:     p.LimitIP,
+		LimitQuota:  p.LimitQuota,
+		Duration:    p.Extend,
+	}
+	var oerr *opError
+	if strings.TrimSpace(p.Extend) != "" {
+		_, _, oerr = tx.renew(req)
+	} else {
+		_, oerr = tx.update(req)
+	}
+	if oerr != nil {
+		return UserRecord{}, oerr
+	}
+
+	current := tx.users[idx].Status
+	if p.Status == StatusSuspended && current != StatusSuspended {
+		_, oerr = tx.suspend(UserRequest{Username: username, Reason: p.Reason})
+	} else if p.Status == StatusActive && current == StatusSuspended {
+		_, oerr = tx.unsuspend(UserRequest{Username: username})
+	}
+	if oerr != nil {
+		return UserRecord{}, oerr
+	}
+	return tx.users[idx], nil
+}
+
+// requireScope dipakai endpoint yang scope-nya berbeda per method.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if scopeAllows(requestKey(r).Scope, scope) {
+		return true
+	}
+	errorResponse(w, http.StatusForbidden, ErrForbidden, "API key tidak punya izin "+scope)
+	return false
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	errorResponse(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+}
+
+func usersV2(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query, err := parseUserQuery(r.URL.Query())
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+			return
+		}
+		if query.Limit == 0 {
+			query.Limit = UserPageDefaultLimit
+		}
+		userList, err := queryUsers(requestKey(r), query)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, ErrInternal, "Gagal membaca database user")
+			return
+		}
+		jsonResponse(w, http.StatusOK, true, "Daftar user", pageUsers(userList, query))
+
+	case http.MethodPost:
+		if !requireScope(w, r, ScopeUserWrite) {
+			return
+		}
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errorResponse(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		tx, oerr := beginUserTx(requestKey(r))
+		if oerr != nil {
+			opResponse(w, oerr)
+			return
+		}
+		record, _, oerr := tx.create(req)
+		if oerr != nil {
+			opResponse(w, oerr)
+			return
+		}
+		if err := tx.commit(); err != nil {
+			log.Printf("Gagal menyimpan user %s: %v", record.Username, err)
+			errorResponse(w, http.StatusInternalServerError, ErrInternal, "Gagal menyimpan config dan database user")
+			return
+		}
+		w.Header().Set("Location", "/api/v2/users/"+url.PathEscape(record.Username))
+		jsonResponse(w, http.StatusCreated, true, "User berhasil dibuat", userInfo(record, time.Now()))
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+func userV2(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v2/users/")
+	if id == "" || strings.Contains(id, "/") {
+		errorResponse(w, http.StatusNotFound, ErrNotFound, "Endpoint tidak ditemukan")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		mutex.Lock()
+		users, err := store.Load()
+		mutex.Unlock()
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, ErrInternal, "Gagal membaca database user")
+			return
+		}
+		idx := lookupOwnedUser(users, requestKey(r), id, "")
+		if idx < 0 {
+			errorResponse(w, http.StatusNotFound, ErrUserNotFound, "User tidak ditemukan")
+			return
+		}
+		jsonResponse(w, http.StatusOK, true, "Detail user", userInfo(users[idx], time.Now()))
+
+	case http.MethodPatch:
+		if !requireScope(w, r, ScopeUserWrite) {
+			return
+		}
+		var patch UserPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			errorResponse(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		tx, oerr := beginUserTx(requestKey(r))
+		if oerr != nil {
+			opResponse(w, oerr)
+			return
+		}
+		u, oerr := tx.patch(id, patch)
+		if oerr != nil {
+			tx.rollback()
+			opResponse(w, oerr)
+			return
+		}
+		if err := tx.commit(); err != nil {
+			log.Printf("Gagal memperbarui user %s: %v", id, err)
+			errorResponse(w, http.StatusInternalServerError, ErrInternal, "Gagal menyimpan config dan database user")
+			return
+		}
+		jsonResponse(w, http.StatusOK, true, "User diperbarui", userInfo(u, time.Now()))
+
+	case http.MethodDelete:
+		if !requireScope(w, r, ScopeUserWrite) {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		tx, oerr := beginUserTx(requestKey(r))
+		if oerr != nil {
+			opResponse(w, oerr)
+			return
+		}
+		if _, oerr := tx.delete(UserRequest{Username: id}); oerr != nil {
+			opResponse(w, oerr)
+			return
+		}
+		if err := tx.commit(); err != nil {
+			log.Printf("Gagal menghapus user %s: %v", id, err)
+			errorResponse(w, http.StatusInternalServerError, ErrInternal, "Gagal menyimpan config dan database user")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	}
 }
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRunner mencatat setiap perintah dan mengembalikan output yang sudah
+// disiapkan per perintah (kunci: "nama arg1 arg2 ...").
+type fakeRunner struct {
+	calls   []string
+	outputs map[string]string
+	fails   map[string]bool
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{outputs: make(map[string]string), fails: make(map[string]bool)}
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, cmd)
+	if f.fails[cmd] {
+		return []byte("iptables: Bad rule."), errors.New("exit status 1")
+	}
+	return []byte(f.outputs[cmd]), nil
+}
+
+func (f *fakeRunner) called(cmd string) bool {
+	for _, c := range f.calls {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// Output asli "iptables -L ZIVPN_ACCT -n -v -x -Z" dengan dua client.
+const acctListing = `Chain ZIVPN_ACCT (2 references)
+    pkts      bytes target     prot opt in     out     source               destination
+     120    15360 RETURN     udp  --  *      *       10.0.0.5             0.0.0.0/0            udp dpt:5667
+      80   204800 RETURN     udp  --  *      *       0.0.0.0/0            10.0.0.5             udp spt:5667
+       0        0 RETURN     udp  --  *      *       10.0.0.9             0.0.0.0/0            udp dpt:5667
+       3      512 RETURN     udp  --  *      *       0.0.0.0/0            10.0.0.9             udp spt:5667
+Zeroing chain ` + "`ZIVPN_ACCT'" + `
+`
+
+const acctList = "iptables -L ZIVPN_ACCT -n -v -x -Z"
+
+func TestParseAcctCounters(t *testing.T) {
+	got := parseAcctCounters(acctListing)
+	want := map[string]int64{"10.0.0.5": 15360 + 204800, "10.0.0.9": 512}
+	if len(got) != len(want) {
+		t.Fatalf("parseAcctCounters = %v, want %v", got, want)
+	}
+	for ip, b := range want {
+		if got[ip] != b {
+			t.Errorf("bytes %s = %d, want %d", ip, got[ip], b)
+		}
+	}
+}
+
+func TestAccountingSetup(t *testing.T) {
+	r := newFakeRunner()
+	// OUTPUT sudah terpasang, INPUT belum
+	r.fails["iptables -C INPUT -j ZIVPN_ACCT"] = true
+	a := newTrafficAccounting(r, "5667")
+	if err := a.Setup(); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	for _, cmd := range []string{
+		"iptables -N ZIVPN_ACCT",
+		"iptables -F ZIVPN_ACCT",
+		"iptables -I INPUT -j ZIVPN_ACCT",
+	} {
+		if !r.called(cmd) {
+			t.Errorf("perintah %q tidak dijalankan; calls=%v", cmd, r.calls)
+		}
+	}
+	if r.called("iptables -I OUTPUT -j ZIVPN_ACCT") {
+		t.Errorf("jump OUTPUT dipasang dua kali")
+	}
+
+	r = newFakeRunner()
+	r.fails["iptables -F ZIVPN_ACCT"] = true
+	a = newTrafficAccounting(r, "5667")
+	if err := a.Setup(); err == nil {
+		t.Fatalf("Setup harus gagal jika flush gagal")
+	}
+	if err := a.Track("alice", "10.0.0.5:40000"); err != nil || len(a.IPs("alice")) != 0 {
+		t.Errorf("Track saat accounting nonaktif harus diabaikan")
+	}
+}
+
+func TestAccountingTrack(t *testing.T) {
+	r := newFakeRunner()
+	a := newTrafficAccounting(r, "5667")
+	if err := a.Setup(); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	r.calls = nil
+
+	if err := a.Track("alice", "10.0.0.5:40000"); err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+	want := []string{
+		"iptables -A ZIVPN_ACCT -p udp --dport 5667 -s 10.0.0.5 -j RETURN",
+		"iptables -A ZIVPN_ACCT -p udp --sport 5667 -d 10.0.0.5 -j RETURN",
+	}
+	if strings.Join(r.calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls = %v, want %v", r.calls, want)
+	}
+
+	// IP yang sama tidak memasang rule lagi, hanya pindah pemilik
+	r.calls = nil
+	if err := a.Track("bob", "10.0.0.5:40001"); err != nil {
+		t.Fatalf("Track ulang: %v", err)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("rule dipasang ulang: %v", r.calls)
+	}
+	if ips := a.IPs("bob"); len(ips) != 1 || ips[0] != "10.0.0.5" {
+		t.Errorf("IPs(bob) = %v", ips)
+	}
+
+	// IPv6 tidak dihitung
+	if err := a.Track("bob", "[2001:db8::1]:40000"); err != nil || len(r.calls) != 0 {
+		t.Errorf("IPv6 tidak boleh memasang rule: err=%v calls=%v", err, r.calls)
+	}
+
+	r.fails[strings.Replace(want[0], "10.0.0.5", "10.0.0.6", 1)] = true
+	if err := a.Track("carol", "10.0.0.6:1"); err == nil {
+		t.Errorf("Track harus mengembalikan error jika iptables gagal")
+	}
+	if len(a.IPs("carol")) != 0 {
+		t.Errorf("IP yang gagal dipasang tidak boleh dicatat")
+	}
+}
+
+func TestAccountingCollectReset(t *testing.T) {
+	r := newFakeRunner()
+	a := newTrafficAccounting(r, "5667")
+	if err := a.Setup(); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	a.Track("alice", "10.0.0.5:40000")
+	a.Track("bob", "10.0.0.9:40000")
+	r.outputs[acctList] = acctListing
+
+	if err := a.Collect(); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !r.called(acctList) {
+		t.Fatalf("Collect tidak membaca chain dengan -Z; calls=%v", r.calls)
+	}
+	if got := a.Pending("alice"); got != 220160 {
+		t.Errorf("Pending(alice) = %d, want 220160", got)
+	}
+	if got := a.Pending("bob"); got != 512 {
+		t.Errorf("Pending(bob) = %d, want 512", got)
+	}
+
+	// counter sudah di-zero, pembacaan kedua menambah di atas pending
+	if err := a.Collect(); err != nil {
+		t.Fatalf("Collect kedua: %v", err)
+	}
+	if got := a.Pending("alice"); got != 2*220160 {
+		t.Errorf("Pending(alice) setelah dua Collect = %d", got)
+	}
+
+	a.Reset("alice")
+	if got := a.Pending("alice"); got != 0 {
+		t.Errorf("Pending(alice) setelah Reset = %d", got)
+	}
+	if got := a.Pending("bob"); got != 1024 {
+		t.Errorf("Reset alice tidak boleh menyentuh bob: %d", got)
+	}
+
+	drained := a.Drain()
+	if drained["bob"] != 1024 || a.Pending("bob") != 0 {
+		t.Errorf("Drain = %v, pending bob = %d", drained, a.Pending("bob"))
+	}
+	a.Restore(drained)
+	if a.Pending("bob") != 1024 {
+		t.Errorf("Restore tidak mengembalikan pending")
+	}
+
+	r.fails[acctList] = true
+	if err := a.Collect(); err == nil {
+		t.Errorf("Collect harus gagal jika iptables -L gagal")
+	}
+}
+
+// useTestStore mengarahkan config.json dan database user ke direktori
+// sementara. Password user yang tidak di-suspend dimasukkan ke config.
+func useTestStore(t *testing.T, mode string, users []UserRecord) {
+	t.Helper()
+	dir := t.TempDir()
+	oldPath, oldStore := configPath, store
+	configPath = filepath.Join(dir, "config.json")
+	store = &fileStore{path: filepath.Join(dir, "users.db")}
+	t.Cleanup(func() { configPath, store = oldPath, oldStore })
+
+	config := Config{Auth: AuthConfig{Mode: mode}}
+	for _, u := range users {
+		if u.Status != StatusSuspended {
+			config.Auth.Config = append(config.Auth.Config, u.Password)
+		}
+	}
+	if err := saveConfigAndUsers(config, users); err != nil {
+		t.Fatalf("menyiapkan store: %v", err)
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	future := now.Add(48 * time.Hour).Format(time.RFC3339)
+	past := now.Add(-48 * time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name string
+		u    UserRecord
+		want string
+	}{
+		{"aktif", UserRecord{Expired: future, Status: StatusActive}, "Active"},
+		{"tanpa expired", UserRecord{Status: StatusActive}, "Active"},
+		{"lewat expired", UserRecord{Expired: past, Status: StatusActive}, "Expired"},
+		{"dinonaktifkan enforceExpiry", UserRecord{Expired: past, Status: StatusSuspended, Reason: ReasonExpired}, "Expired"},
+		{"suspend manual", UserRecord{Expired: future, Status: StatusSuspended, Reason: ReasonManual}, "Suspended"},
+		{"suspend kuota", UserRecord{Expired: future, Status: StatusSuspended, Reason: ReasonQuota}, "Suspended"},
+		{"suspend manual lalu expired", UserRecord{Expired: past, Status: StatusSuspended, Reason: ReasonManual}, "Expired"},
+	}
+	for _, tt := range tests {
+		if got := userStatus(tt.u, now); got != tt.want {
+			t.Errorf("%s: userStatus = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := userInfo(tt.u, now).Status; got != tt.want {
+			t.Errorf("%s: userInfo.Status = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserQueryMatch(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	soon := now.Add(12 * time.Hour).Format(time.RFC3339)
+	later := now.Add(30 * 24 * time.Hour).Format(time.RFC3339)
+	past := now.Add(-time.Hour).Format(time.RFC3339)
+
+	users := map[string]UserRecord{
+		"soon":     {Username: "soon", Password: "p1", Expired: soon, Status: StatusActive, Owner: "r1"},
+		"later":    {Username: "later", Password: "p2", Expired: later, Status: StatusActive, DisplayName: "Budi"},
+		"disabled": {Username: "disabled", Password: "p3", Expired: past, Status: StatusSuspended, Reason: ReasonExpired},
+		"manual":   {Username: "manual", Password: "p4", Expired: later, Status: StatusSuspended, Reason: ReasonManual},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"disabled", "later", "manual", "soon"}},
+		{"status=expired", []string{"disabled"}},
+		{"status=suspended", []string{"manual"}},
+		{"status=active,expired", []string{"disabled", "later", "soon"}},
+		{"expiring_within=1d", []string{"soon"}},
+		{"owner=admin", []string{"disabled", "later", "manual"}},
+		{"owner=r1", []string{"soon"}},
+		{"q=budi", []string{"later"}},
+		{"q=P4", []string{"manual"}},
+	}
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		q, err := parseUserQuery(v)
+		if err != nil {
+			t.Fatalf("parseUserQuery(%q): %v", tt.query, err)
+		}
+		var got []string
+		for _, name := range []string{"disabled", "later", "manual", "soon"} {
+			u := users[name]
+			if q.match(u, userInfo(u, now), now) {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q: match = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"status=deleted", "sort=password", "limit=0", "cursor=!!", "expiring_within=x"} {
+		v, _ := url.ParseQuery(bad)
+		if _, err := parseUserQuery(v); err == nil {
+			t.Errorf("parseUserQuery(%q) harus gagal", bad)
+		}
+	}
+}
+
+func TestPageUsersCursor(t *testing.T) {
+	var list []UserInfo
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		list = append(list, UserInfo{Username: name, sortKey: name + "\x00" + name})
+	}
+
+	var got []string
+	v := url.Values{"sort": {"username"}, "limit": {"2"}}
+	for pages := 0; pages < 5; pages++ {
+		q, err := parseUserQuery(v)
+		if err != nil {
+			t.Fatalf("parseUserQuery: %v", err)
+		}
+		page := pageUsers(list, q)
+		if page.Total != len(list) {
+			t.Fatalf("Total = %d", page.Total)
+		}
+		for _, u := range page.Users {
+			got = append(got, u.Username)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		v.Set("cursor", page.NextCursor)
+	}
+	if strings.Join(got, ",") != "a,b,c,d,e" {
+		t.Errorf("halaman berurutan = %v", got)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{"2026-06-01T10:00:00+07:00", time.Date(2026, 6, 1, 3, 0, 0, 0, time.UTC), false},
+		{" 2026-06-01T03:00:00Z ", time.Date(2026, 6, 1, 3, 0, 0, 0, time.UTC), false},
+		{"2026-06-01 10:30:00", time.Date(2026, 6, 1, 10, 30, 0, 0, time.Local), false},
+		// tanggal saja berlaku sampai akhir hari
+		{"2026-06-01", time.Date(2026, 6, 2, 0, 0, 0, 0, time.Local), false},
+		{"", time.Time{}, true},
+		{"besok", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseExpiry(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !tt.err && !got.Equal(tt.want) {
+			t.Errorf("parseExpiry(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	future := formatExpiry(time.Now().Add(24 * time.Hour))
+	past := formatExpiry(time.Now().Add(-time.Hour))
+	useTestStore(t, AuthModeExternal, []UserRecord{
+		{Username: "alice", Password: "alice1", Expired: future, Status: StatusActive},
+		{Username: "bob", Password: "bob1", Expired: future, Status: StatusSuspended, Reason: ReasonManual},
+		{Username: "carol", Password: "carol1", Expired: past, Status: StatusActive},
+	})
+	// password di config tanpa record database
+	config, _ := loadConfig()
+	config.Auth.Config = append(config.Auth.Config, "orphan")
+	if err := saveConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		user     string
+		ok       bool
+		msg      string
+	}{
+		{"alice1", "alice", true, "ok"},
+		{"", "", false, "password kosong"},
+		{"bob1", "", false, "user tidak aktif"},
+		{"salah", "", false, "user tidak aktif"},
+		{"carol1", "", false, "user expired"},
+		{"orphan", "", false, "user tidak ditemukan"},
+	}
+	for _, tt := range tests {
+		user, ok, msg := authorizeUser(tt.password, "10.0.0.5:40000")
+		if user != tt.user || ok != tt.ok || msg != tt.msg {
+			t.Errorf("authorizeUser(%q) = %q, %v, %q; want %q, %v, %q", tt.password, user, ok, msg, tt.user, tt.ok, tt.msg)
+		}
+	}
+}
+
+func TestDeviceTrackerAdmit(t *testing.T) {
+	d := newDeviceTracker()
+	now := time.Now()
+	window := 10 * time.Minute
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		if _, ok := d.Admit("budi", ip, 2, window, now); !ok {
+			t.Fatalf("IP %s harus diterima", ip)
+		}
+	}
+	active, ok := d.Admit("budi", "10.0.0.3", 2, window, now)
+	if ok || len(active) != 2 {
+		t.Fatalf("IP ketiga harus ditolak, active=%v ok=%v", active, ok)
+	}
+	if _, ok := d.Admit("andi", "10.0.0.3", 2, window, now); !ok {
+		t.Errorf("limit dihitung per user")
+	}
+	// IP lama keluar dari window sehingga slot kosong lagi
+	if _, ok := d.Admit("budi", "10.0.0.3", 2, window, now.Add(window+time.Second)); !ok {
+		t.Errorf("IP baru harus diterima setelah window lewat")
+	}
+}
+
+func postBulk(t *testing.T, req BulkRequest) (int, Response, []BulkResult) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	bulkUsers(rec, httptest.NewRequest(http.MethodPost, "/api/users/bulk", strings.NewReader(string(body))))
+
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("respon bukan JSON: %s", rec.Body.String())
+	}
+	var results []BulkResult
+	raw, _ := json.Marshal(resp.Data)
+	json.Unmarshal(raw, &results)
+	return rec.Code, resp, results
+}
+
+func TestBulkUsers(t *testing.T) {
+	useTestStore(t, AuthModePasswords, []UserRecord{
+		{Username: "andi", Password: "andi1", Expired: formatExpiry(time.Now().Add(24 * time.Hour)), Status: StatusActive},
+	})
+
+	op := func(name string, req UserRequest) BulkOperation {
+		return BulkOperation{Op: name, UserRequest: req}
+	}
+	code, resp, results := postBulk(t, BulkRequest{Operations: []BulkOperation{
+		op("create", UserRequest{Username: "budi", Password: "budi1", Days: 30}),
+		op("create", UserRequest{Password: "andi1", Days: 1}),
+		op("renew", UserRequest{Username: "nobody", Days: 1}),
+		op("delete", UserRequest{Username: "andi"}),
+		op("rename", UserRequest{Username: "budi"}),
+		op("create", UserRequest{Password: "cici1", Days: 0}),
+	}})
+	if code != http.StatusOK || resp.Success || resp.Message != "2 berhasil, 4 gagal" {
+		t.Fatalf("respon = %d %+v", code, resp)
+	}
+
+	want := []struct {
+		success bool
+		status  int
+		code    string
+	}{
+		{true, http.StatusOK, ""},
+		{false, http.StatusConflict, ErrUserExists},
+		{false, http.StatusNotFound, ErrUserNotFound},
+		{true, http.StatusOK, ""},
+		{false, http.StatusBadRequest, ErrInvalidRequest},
+		{false, http.StatusBadRequest, ErrInvalidRequest},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("jumlah hasil = %d, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Index != i || r.Success != w.success || r.Status != w.status || r.Code != w.code {
+			t.Errorf("hasil %d = %+v, want %+v", i, r, w)
+		}
+	}
+
+	users, _ := store.Load()
+	if len(users) != 1 || users[0].Username != "budi" {
+		t.Fatalf("database setelah bulk = %+v", users)
+	}
+	config, _ := loadConfig()
+	if strings.Join(config.Auth.Config, ",") != "budi1" {
+		t.Errorf("config setelah bulk = %v", config.Auth.Config)
+	}
+
+	// atomic: satu operasi gagal membatalkan semuanya
+	code, resp, _ = postBulk(t, BulkRequest{Atomic: true, Operations: []BulkOperation{
+		op("create", UserRequest{Username: "cici", Password: "cici1", Days: 30}),
+		op("renew", UserRequest{Username: "nobody", Days: 1}),
+	}})
+	if code != http.StatusBadRequest || resp.Success {
+		t.Fatalf("respon atomic = %d %+v", code, resp)
+	}
+	if users, _ := store.Load(); len(users) != 1 {
+		t.Errorf("atomic yang gagal tidak boleh menyimpan perubahan: %+v", users)
+	}
+
+	if code, _, _ := postBulk(t, BulkRequest{}); code != http.StatusBadRequest {
+		t.Errorf("bulk tanpa operasi = %d, want 400", code)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -26,33 +28,63 @@ const (
 	// !!! GANTI INI DENGAN URL GAMBAR MENU ANDA !!!
 	MenuPhotoURL = "https://raw.githubusercontent.com/skynet-vpn/logo/main/logo.png"
 
-	// Interval untuk pengecekan dan penghapusan akun expired
-	AutoDeleteInterval = 30 * time.Second
+	// Interval untuk mengirim pengingat expired
+	ReminderInterval = 30 * time.Second
+	// Jumlah user per request /users/bulk saat restore
+	BulkChunkSize = 500
+	// Jeda sebelum menyambung ulang stream event API yang terputus
+	EventRetryDelay = 5 * time.Second
+	// Event yang datang berdekatan digabung dalam satu notifikasi
+	EventBatchDelay = 3 * time.Second
 	// Interval untuk Auto Backup (3 jam)
 	AutoBackupInterval = 3 * time.Hour
 
 	// Konfigurasi Backup dan Service
 	BackupDir   = "/etc/zivpn/backups"
 	ServiceName = "zivpn"
+
+	// ReminderStateFile mencatat pengingat expired yang sudah terkirim agar
+	// tidak dikirim ulang setelah bot restart.
+	ReminderStateFile = "/etc/zivpn/reminders.json"
 )
 
-var ApiKey = "AutoFtBot-agskjgdvsbdreiWG1234512SDKrqw"
+// DefaultReminderThresholds dipakai jika reminder_thresholds di config kosong.
+var DefaultReminderThresholds = []string{"3d", "1d", "1h"}
+
+// ApiKey dibaca dari api_key di bot-config.json, atau dari ApiKeyFile
+// jika bot berjalan di server yang sama dengan API.
+var ApiKey string
 
 var startTime time.Time // Global variable untuk menghitung uptime bot
 
-// WIB Timezone (UTC+7)
-var wibLoc *time.Location
+// Zona waktu tampilan. Default WIB (UTC+7), diganti dengan timezone API
+// saat start agar bot dan API menampilkan waktu yang sama.
+var displayLoc *time.Location
 
 func init() {
 	var err error
-	wibLoc, err = time.LoadLocation("Asia/Jakarta")
+	displayLoc, err = time.LoadLocation("Asia/Jakarta")
 	if err != nil {
 		log.Fatal("Gagal load timezone WIB:", err)
 	}
 }
 
-func getNowWIB() time.Time {
-	return time.Now().In(wibLoc)
+func getNowLocal() time.Time {
+	return time.Now().In(displayLoc)
+}
+
+// loadDisplayTimezone mengambil timezone dari /api/info.
+func loadDisplayTimezone() {
+	res, err := apiCall("GET", "/info", nil)
+	if err != nil || res["success"] != true {
+		log.Printf("Gagal membaca timezone API, memakai %s", displayLoc)
+		return
+	}
+	data, _ := res["data"].(map[string]interface{})
+	name, _ := data["timezone"].(string)
+	if loc, err := time.LoadLocation(name); err == nil && name != "" {
+		displayLoc = loc
+	}
 }
 
 type BotConfig struct {
@@ -60,6 +92,18 @@ type BotConfig struct {
 	AdminID        int64  `json:"admin_id"`
 	NotifGroupID   int64  `json:"notif_group_id"`
 	VpsExpiredDate string `json:"vps_expired_date"` // Format: 2006-01-02
+
+	// ApiKey untuk memanggil API. Kosong berarti memakai ApiKeyFile.
+	ApiKey string `json:"api_key,omitempty"`
+
+	// ReminderThresholds: kapan pengingat dikirim sebelum expired, contoh
+	// ["3d", "1d", "1h"]. Satuan "d" untuk hari, selain itu format durasi Go.
+	ReminderThresholds []string `json:"reminder_thresholds,omitempty"`
+
+	// GracePeriod dan PurgePolicy sekarang diatur API di api-config.json.
+	// Field tetap disimpan agar nilai lama tidak hilang saat config ditulis.
+	GracePeriod string `json:"grace_period,omitempty"`
+	PurgePolicy string `json:"purge_policy,omitempty"`
 }
 
 type IpInfo struct {
@@ -68,10 +112,19 @@ type IpInfo struct {
 }
 
 type UserData struct {
-	Host     string `json:"host"` // Host untuk backup
-	Password string `json:"password"`
-	Expired  string `json:"expired"`
-	Status   string `json:"status"`
+	Host        string `json:"host"` // Host untuk backup
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Contact     string `json:"contact,omitempty"`
+	TelegramID  int64  `json:"telegram_id,omitempty"`
+	Owner       string `json:"owner,omitempty"` // ID reseller pemilik akun
+	Password    string `json:"password"`
+	Expired     string `json:"expired"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+	LimitIP     int    `json:"limit_ip"`
+	LimitQuota  int    `json:"limit_quota"`
+	UsageBytes  int64  `json:"usage_bytes"`
 }
 
 // Variabel global dengan Mutex untuk keamanan konkurensi (Thread-Safe)
@@ -80,8 +133,21 @@ var (
 	userStates     = make(map[int64]string)
 	tempUserData   = make(map[int64]map[string]string)
 	lastMessageIDs = make(map[int64]int)
+	userViews      = make(map[int64]*userListView)
+
+	// ID event API terakhir yang sudah diproses
+	eventCursor int64
 )
 
+// ApiEvent adalah event dari endpoint /api/events.
+type ApiEvent struct {
+	ID       int64                  `json:"id"`
+	Time     string                 `json:"time"`
+	Type     string                 `json:"type"`
+	Username string                 `json:"username"`
+	Data     map[string]interface{} `json:"data"`
+}
+
 func main() {
 	startTime = time.Now() // Set waktu mulai bot
 	rand.Seed(time.Now().UnixNano())
@@ -90,16 +156,22 @@ func main() {
 		log.Printf("Gagal membuat direktori backup: %v", err)
 	}
 
-	if keyBytes, err := os.ReadFile(ApiKeyFile); err == nil {
-		ApiKey = strings.TrimSpace(string(keyBytes))
-	}
-
 	// Load config awal
 	config, err := loadConfig()
 	if err != nil {
 		log.Fatal("Gagal memuat konfigurasi bot:", err)
 	}
 
+	ApiKey = strings.TrimSpace(config.ApiKey)
+	if ApiKey == "" {
+		if keyBytes, err := os.ReadFile(ApiKeyFile); err == nil {
+			ApiKey = strings.TrimSpace(string(keyBytes))
+		}
+	}
+	if ApiKey == "" {
+		log.Fatalf("API key tidak ditemukan: isi api_key di %s atau %s", BotConfigFile, ApiKeyFile)
+	}
+
 	bot, err := tgbotapi.NewBotAPI(config.BotToken)
 	if err != nil {
 		log.Panic(err)
@@ -108,12 +180,23 @@ func main() {
 	bot.Debug = false
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	// --- BACKGROUND WORKER (PENGHAPUSAN OTOMATIS) ---
+	loadDisplayTimezone()
+
+	// --- BACKGROUND WORKER (NOTIFIKASI EVENT) ---
+	// Penonaktifan dan penghapusan user expired dilakukan API; bot hanya
+	// mengirim notifikasi dari stream event yang dihasilkan.
+	go func() {
+		// Event yang terjadi sebelum bot start tidak dinotifikasi ulang
+		seedEventCursor()
+		subscribeEvents(bot, config.AdminID)
+	}()
+
+	// --- BACKGROUND WORKER (PENGINGAT EXPIRED) ---
 	go func() {
-		autoDeleteExpiredUsers(bot, config.AdminID, false)
-		ticker := time.NewTicker(AutoDeleteInterval)
+		sendExpiryReminders(bot, config.AdminID)
+		ticker := time.NewTicker(ReminderInterval)
 		for range ticker.C {
-			autoDeleteExpiredUsers(bot, config.AdminID, false)
+			sendExpiryReminders(bot, config.AdminID)
 		}
 	}()
 
@@ -228,22 +311,38 @@ func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, adminID
 
 	switch {
 	case callbackData == "menu_trial":
+		randomUser := "trial-" + strings.ToLower(generateRandomPassword(4))
 		randomPass := generateRandomPassword(4)
 		// Simpan data sementara dan minta admin memasukkan durasi trial
 		setState(query.From.ID, "create_trial_duration")
-		setTempData(query.From.ID, map[string]string{"username": randomPass, "limit_ip": "1", "limit_quota": "1"})
+		setTempData(query.From.ID, map[string]string{"username": randomUser, "password": randomPass, "limit_ip": "1", "limit_quota": "1"})
 		sendMessage(bot, query.Message.Chat.ID, "🎁 *TRIAL*\nSilakan masukkan durasi trial.\nContoh: `1h` = 1 jam, `1d` = 1 hari.\nAtau masukkan angka saja untuk hari (Contoh: `1` = 1 hari).")
 
 	case callbackData == "menu_create":
 		setState(query.From.ID, "create_username")
 		setTempData(query.From.ID, make(map[string]string))
-		sendMessage(bot, query.Message.Chat.ID, "🔑 *MENU CREATE*\nSilakan masukkan **PASSWORD**:")
+		sendMessage(bot, query.Message.Chat.ID, "👤 *MENU CREATE*\nSilakan masukkan **USERNAME** (3-32 karakter: huruf, angka, `.`, `-`, `_`):")
 	case callbackData == "menu_delete":
 		showUserSelection(bot, query.Message.Chat.ID, 1, "delete")
 	case callbackData == "menu_renew":
 		showUserSelection(bot, query.Message.Chat.ID, 1, "renew")
+	case callbackData == "menu_rename":
+		showUserSelection(bot, query.Message.Chat.ID, 1, "rename")
+	case callbackData == "menu_link":
+		showUserSelection(bot, query.Message.Chat.ID, 1, "link")
+	case callbackData == "menu_suspend":
+		showUserSelection(bot, query.Message.Chat.ID, 1, "suspend")
+	case callbackData == "menu_unsuspend":
+		showUserSelection(bot, query.Message.Chat.ID, 1, "unsuspend")
 	case callbackData == "menu_list":
-		listUsers(bot, query.Message.Chat.ID)
+		showListFilters(bot, query.Message.Chat.ID)
+	case strings.HasPrefix(callbackData, "list_"):
+		filter := strings.TrimPrefix(callbackData, "list_")
+		openUserView(query.Message.Chat.ID, "list", listFilterQuery(filter))
+		listUsers(bot, query.Message.Chat.ID, 1)
+	case callbackData == "menu_search":
+		setState(query.From.ID, "search_query")
+		sendMessage(bot, query.Message.Chat.ID, "🔎 *CARI USER*\nMasukkan **kata kunci** (username, password, nama atau kontak):")
 	case callbackData == "menu_info":
 		systemInfo(bot, query.Message.Chat.ID)
 
@@ -271,6 +370,23 @@ func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, adminID
 	case callbackData == "menu_clean_restart":
 		cleanAndRestartService(bot, query.Message.Chat.ID)
 
+	case callbackData == "menu_violations":
+		showViolations(bot, query.Message.Chat.ID)
+	case callbackData == "menu_reconcile":
+		showReconcile(bot, query.Message.Chat.ID)
+	case callbackData == "reconcile_fix":
+		setState(query.From.ID, "reconcile_days")
+		sendMessage(bot, query.Message.Chat.ID, "🔍 *PERBAIKI SINKRON*\n\nMasukkan **Masa Aktif** (*Hari*) untuk password yang dicatat ke DB:")
+	case callbackData == "reconcile_clean":
+		applyReconcile(bot, query.Message.Chat.ID, "remove", "remove", 0)
+
+	case callbackData == "menu_resellers":
+		showResellers(bot, query.Message.Chat.ID)
+	case callbackData == "reseller_add":
+		setState(query.From.ID, "reseller_name")
+		setTempData(query.From.ID, make(map[string]string))
+		sendMessage(bot, query.Message.Chat.ID, "🤝 *TAMBAH RESELLER*\nMasukkan **Nama Reseller**:")
+
 	case callbackData == "cancel":
 		resetState(query.From.ID)
 		showMainMenu(bot, query.Message.Chat.ID) // Reload otomatis di dalam fungsi
@@ -279,7 +395,14 @@ func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, adminID
 		parts := strings.Split(callbackData, ":")
 		action := parts[0][5:]
 		page, _ := strconv.Atoi(parts[1])
-		showUserSelection(bot, query.Message.Chat.ID, page, action)
+		if action == "list" {
+			listUsers(bot, query.Message.Chat.ID, page)
+		} else {
+			showUserSelection(bot, query.Message.Chat.ID, page, action)
+		}
+
+	case strings.HasPrefix(callbackData, "select_search:"):
+		showUserActions(bot, query.Message.Chat.ID, strings.TrimPrefix(callbackData, "select_search:"))
 
 	case strings.HasPrefix(callbackData, "select_renew:"):
 		username := strings.TrimPrefix(callbackData, "select_renew:")
@@ -302,6 +425,52 @@ func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, adminID
 	case strings.HasPrefix(callbackData, "confirm_delete:"):
 		username := strings.TrimPrefix(callbackData, "confirm_delete:")
 		deleteUser(bot, query.Message.Chat.ID, username)
+
+	case strings.HasPrefix(callbackData, "select_rename:"):
+		username := strings.TrimPrefix(callbackData, "select_rename:")
+		setTempData(query.From.ID, map[string]string{"username": username})
+		setState(query.From.ID, "rename_new_password")
+		sendMessage(bot, query.Message.Chat.ID, fmt.Sprintf("🔐 *GANTI PASSWORD*\nUser: `%s`\n\nMasukkan **Password Baru**:", username))
+
+	case strings.HasPrefix(callbackData, "select_link:"):
+		username := strings.TrimPrefix(callbackData, "select_link:")
+		setTempData(query.From.ID, map[string]string{"username": username})
+		setState(query.From.ID, "link_telegram_id")
+		sendMessage(bot, query.Message.Chat.ID, fmt.Sprintf("📱 *LINK TELEGRAM*\nUser: `%s`\n\nMasukkan **Chat ID Telegram** pelanggan (ketik `-` untuk melepas):", username))
+
+	case strings.HasPrefix(callbackData, "select_suspend:"):
+		username := strings.TrimPrefix(callbackData, "select_suspend:")
+		setTempData(query.From.ID, map[string]string{"username": username})
+		setState(query.From.ID, "suspend_reason")
+		sendMessage(bot, query.Message.Chat.ID, fmt.Sprintf("⏸️ *SUSPEND AKUN*\nUser: `%s`\n\nMasukkan **Alasan** suspend (ketik `-` jika tanpa alasan):", username))
+
+	case strings.HasPrefix(callbackData, "select_unsuspend:"):
+		username := strings.TrimPrefix(callbackData, "select_unsuspend:")
+		unsuspendUser(bot, query.Message.Chat.ID, username)
+
+	case strings.HasPrefix(callbackData, "select_reseller:"):
+		showResellerDetail(bot, query.Message.Chat.ID, strings.TrimPrefix(callbackData, "select_reseller:"))
+
+	case strings.HasPrefix(callbackData, "reseller_topup:"):
+		id := strings.TrimPrefix(callbackData, "reseller_topup:")
+		setTempData(query.From.ID, map[string]string{"reseller_id": id})
+		setState(query.From.ID, "reseller_topup_amount")
+		sendMessage(bot, query.Message.Chat.ID, "💰 *TOPUP SALDO*\n\nMasukkan **Jumlah Saldo** (gunakan angka negatif untuk mengurangi):")
+
+	case strings.HasPrefix(callbackData, "reseller_delete:"):
+		id := strings.TrimPrefix(callbackData, "reseller_delete:")
+		msg := tgbotapi.NewMessage(query.Message.Chat.ID, "❓ *KONFIRMASI HAPUS RESELLER*\nAPI key reseller akan dicabut. Akun milik reseller tetap ada.")
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✅ Ya, Hapus", "confirm_reseller_delete:"+id),
+				tgbotapi.NewInlineKeyboardButtonData("❌ Batal", "menu_resellers"),
+			),
+		)
+		sendAndTrack(bot, msg)
+
+	case strings.HasPrefix(callbackData, "confirm_reseller_delete:"):
+		deleteReseller(bot, query.Message.Chat.ID, strings.TrimPrefix(callbackData, "confirm_reseller_delete:"))
 	}
 
 	bot.Request(tgbotapi.NewCallback(query.ID, ""))
@@ -362,9 +531,26 @@ func handleState(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, state string) {
 		showMainMenu(bot, msg.Chat.ID) // Akan reload config otomatis
 
 	case "create_username":
+		if !validUsername(text) {
+			sendMessage(bot, msg.Chat.ID, "❌ Username harus 3-32 karakter: huruf, angka, `.`, `-` atau `_`.")
+			return
+		}
 		stateMutex.Lock()
 		tempUserData[userID] = map[string]string{"username": text}
 		stateMutex.Unlock()
+		setState(userID, "create_password")
+		sendMessage(bot, msg.Chat.ID, fmt.Sprintf("👤 *CREATE USER*\nUsername: `%s`\n\nMasukkan **PASSWORD**:", text))
+
+	case "create_password":
+		if text == "" || strings.ContainsAny(text, " |") {
+			sendMessage(bot, msg.Chat.ID, "❌ Password tidak boleh kosong atau mengandung spasi / `|`.")
+			return
+		}
+		stateMutex.Lock()
+		if data, ok := tempUserData[userID]; ok {
+			data["password"] = text
+		}
+		stateMutex.Unlock()
 		setState(userID, "create_limit_ip")
 		sendMessage(bot, msg.Chat.ID, fmt.Sprintf("🔑 *CREATE USER*\nPassword: `%s`\n\nMasukkan **Limit IP**:", text))
 
@@ -407,9 +593,8 @@ func handleState(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, state string) {
 		if ok {
 			limitIP, _ := strconv.Atoi(data["limit_ip"])
 			limitQuota, _ := strconv.Atoi(data["limit_quota"])
-			username := data["username"]
 			currentCfg, _ := loadConfig()
-			createUser(bot, msg.Chat.ID, username, days, "", limitIP, limitQuota, currentCfg)
+			createUser(bot, msg.Chat.ID, data["username"], data["password"], days, "", limitIP, limitQuota, currentCfg)
 			resetState(userID)
 		}
 
@@ -424,7 +609,6 @@ func handleState(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, state string) {
 			return
 		}
 
-		username := data["username"]
 		limitIP, _ := strconv.Atoi(data["limit_ip"])
 		limitQuota, _ := strconv.Atoi(data["limit_quota"])
 
@@ -458,8 +642,130 @@ func handleState(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, state string) {
 		}
 
 		currentCfg, _ := loadConfig()
-		createUser(bot, msg.Chat.ID, username, days, duration, limitIP, limitQuota, currentCfg)
+		createUser(bot, msg.Chat.ID, data["username"], data["password"], days, duration, limitIP, limitQuota, currentCfg)
+		resetState(userID)
+
+	case "search_query":
+		if text == "" {
+			sendMessage(bot, msg.Chat.ID, "❌ Kata kunci tidak boleh kosong.")
+			return
+		}
+		resetState(userID)
+		openUserView(msg.Chat.ID, "search", url.Values{"q": {text}})
+		showUserSelection(bot, msg.Chat.ID, 1, "search")
+
+	case "rename_new_password":
+		data, ok := getTempData(userID)
+		if !ok {
+			sendMessage(bot, msg.Chat.ID, "❌ Data user tidak ditemukan. Silakan ulangi.")
+			resetState(userID)
+			return
+		}
+		if text == "" || strings.ContainsAny(text, " |") {
+			sendMessage(bot, msg.Chat.ID, "❌ Password baru tidak boleh kosong atau mengandung spasi / `|`.")
+			return
+		}
+		username := data["username"]
+		resetState(userID)
+		renamePassword(bot, msg.Chat.ID, username, text)
+
+	case "link_telegram_id":
+		data, ok := getTempData(userID)
+		if !ok {
+			sendMessage(bot, msg.Chat.ID, "❌ Data user tidak ditemukan. Silakan ulangi.")
+			resetState(userID)
+			return
+		}
+		var chatID int64
+		if text != "-" {
+			id, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				sendMessage(bot, msg.Chat.ID, "❌ Chat ID harus berupa angka.")
+				return
+			}
+			chatID = id
+		}
+		username := data["username"]
+		resetState(userID)
+		linkTelegram(bot, msg.Chat.ID, username, chatID)
+
+	case "suspend_reason":
+		data, ok := getTempData(userID)
+		if !ok {
+			sendMessage(bot, msg.Chat.ID, "❌ Data user tidak ditemukan. Silakan ulangi.")
+			resetState(userID)
+			return
+		}
+		reason := text
+		if reason == "-" {
+			reason = ""
+		}
+		username := data["username"]
+		resetState(userID)
+		suspendUser(bot, msg.Chat.ID, username, reason)
+
+	case "reconcile_days":
+		days, err := strconv.Atoi(text)
+		if err != nil || days <= 0 {
+			sendMessage(bot, msg.Chat.ID, "❌ Masa aktif harus angka lebih dari 0.")
+			return
+		}
+		resetState(userID)
+		applyReconcile(bot, msg.Chat.ID, "import", "activate", days)
+
+	case "reseller_name":
+		if text == "" {
+			sendMessage(bot, msg.Chat.ID, "❌ Nama reseller tidak boleh kosong.")
+			return
+		}
+		setTempData(userID, map[string]string{"name": text})
+		setState(userID, "reseller_balance")
+		sendMessage(bot, msg.Chat.ID, "💰 *TAMBAH RESELLER*\n\nMasukkan **Saldo Awal**:")
+
+	case "reseller_balance", "reseller_price", "reseller_max":
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			sendMessage(bot, msg.Chat.ID, "❌ Harus angka 0 atau lebih.")
+			return
+		}
+		data, ok := getTempData(userID)
+		if !ok {
+			sendMessage(bot, msg.Chat.ID, "❌ Data reseller tidak ditemukan. Silakan ulangi.")
+			resetState(userID)
+			return
+		}
+		stateMutex.Lock()
+		data[state] = text
+		stateMutex.Unlock()
+
+		switch state {
+		case "reseller_balance":
+			setState(userID, "reseller_price")
+			sendMessage(bot, msg.Chat.ID, "🏷️ *TAMBAH RESELLER*\n\nMasukkan **Harga per Hari** (saldo yang dipotong per hari akun):")
+		case "reseller_price":
+			setState(userID, "reseller_max")
+			sendMessage(bot, msg.Chat.ID, "👥 *TAMBAH RESELLER*\n\nMasukkan **Maksimal Akun** (`0` = tanpa batas):")
+		default:
+			resetState(userID)
+			balance, _ := strconv.Atoi(data["reseller_balance"])
+			price, _ := strconv.Atoi(data["reseller_price"])
+			createReseller(bot, msg.Chat.ID, data["name"], balance, price, n)
+		}
+
+	case "reseller_topup_amount":
+		amount, err := strconv.Atoi(text)
+		if err != nil || amount == 0 {
+			sendMessage(bot, msg.Chat.ID, "❌ Jumlah harus angka dan tidak boleh 0.")
+			return
+		}
+		data, ok := getTempData(userID)
+		if !ok {
+			sendMessage(bot, msg.Chat.ID, "❌ Data reseller tidak ditemukan. Silakan ulangi.")
+			resetState(userID)
+			return
+		}
 		resetState(userID)
+		topupReseller(bot, msg.Chat.ID, data["reseller_id"], amount)
 
 	case "renew_limit_ip":
 		if _, err := strconv.Atoi(text); err != nil {
@@ -549,85 +855,124 @@ func handleRestoreFromUpload(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
 	successCount := 0
 	skippedCount := 0
 	failedCount := 0
+	var warnings []string
 
+	// Semua user dibuat lewat /users/bulk sehingga service hanya di-restart
+	// sekali, bukan sekali per user.
+	var ops []map[string]interface{}
 	for _, u := range backupUsers {
-		expiredTime, err := time.Parse("2006-01-02", u.Expired)
-		if err != nil {
-			expiredTime, err = time.Parse("2006-01-02 15:04:05", u.Expired)
-			if err != nil {
-				failedCount++
-				continue
-			}
+		expiredTime, ok := userExpiry(u.Expired)
+		if !ok {
+			failedCount++
+			continue
 		}
 
-		duration := time.Until(expiredTime)
-		days := int(duration.Hours() / 24)
+		duration := time.Until(expiredTime).Round(time.Minute)
+		if duration <= 0 {
+			skippedCount++
+			continue
+		}
+		// restore ikut memulihkan status suspend, alasannya dan reseller
+		// pemilik akun, yang tidak bisa dikirim lewat create biasa
+		op := map[string]interface{}{
+			"op":           "restore",
+			"username":     u.Username,
+			"display_name": u.DisplayName,
+			"contact":      u.Contact,
+			"telegram_id":  u.TelegramID,
+			"password":     u.Password,
+			"duration":     duration.String(),
+			"limit_ip":     u.LimitIP,
+			"limit_quota":  u.LimitQuota,
+			"owner":        u.Owner,
+		}
+		if strings.EqualFold(u.Status, "suspended") {
+			op["status"] = "suspended"
+			op["reason"] = u.Reason
+		}
+		ops = append(ops, op)
+	}
 
-		if days > 0 {
-			res, _ := apiCall("POST", "/user/create", map[string]interface{}{
-				"password": u.Password,
-				"days":     days,
-			})
-			if res["success"] == true {
+	for start := 0; start < len(ops); start += BulkChunkSize {
+		end := start + BulkChunkSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+		res, err := apiCall("POST", "/users/bulk", map[string]interface{}{"operations": ops[start:end]})
+		if err != nil {
+			log.Printf("❌ [Restore] Gagal memanggil API bulk: %v", err)
+			failedCount += end - start
+			continue
+		}
+		results, _ := res["data"].([]interface{})
+		for _, item := range results {
+			result, _ := item.(map[string]interface{})
+			switch {
+			case result["success"] == true:
 				successCount++
-			} else {
-				if msg, ok := res["message"].(string); ok {
-					if strings.Contains(strings.ToLower(msg), "already exists") {
-						skippedCount++
-					} else {
-						failedCount++
-					}
-				} else {
-					failedCount++
+				if warning, ok := result["warning"].(string); ok && warning != "" {
+					warnings = append(warnings, fmt.Sprintf("%v: %s", result["username"], warning))
 				}
+			case result["code"] == "user_exists" || result["code"] == "username_taken":
+				skippedCount++
+			default:
+				failedCount++
 			}
-		} else {
-			skippedCount++
 		}
 	}
 
 	msgResult := fmt.Sprintf("✅ *Restore Selesai*\nTotal: %d\n✅ Sukses: %d\n⚠️ Lewati: %d\n❌ Gagal: %d", len(backupUsers), successCount, skippedCount, failedCount)
+	if len(warnings) > 0 {
+		// Biasanya reseller pemilik belum dibuat di server ini
+		for _, w := range warnings {
+			log.Printf("⚠️ [Restore] %s", w)
+		}
+		msgResult += fmt.Sprintf("\n\n⚠️ %d user dipulihkan sebagai milik admin karena resellernya tidak ditemukan (detail di log bot).", len(warnings))
+	}
 	sendMessage(bot, msg.Chat.ID, msgResult)
 	showMainMenu(bot, msg.Chat.ID)
 }
 
 func showUserSelection(bot *tgbotapi.BotAPI, chatID int64, page int, action string) {
-	users, err := getUsers()
+	// Suspend hanya untuk user yang belum suspend, unsuspend sebaliknya
+	var defaults url.Values
+	switch action {
+	case "suspend":
+		defaults = url.Values{"status": {"active,expired"}}
+	case "unsuspend":
+		defaults = url.Values{"status": {"suspended"}}
+	}
+
+	perPage := 10
+	users, total, page, err := loadUserPage(chatID, action, page, perPage, defaults)
 	if err != nil {
 		sendMessage(bot, chatID, "❌ Gagal mengambil data user.")
 		return
 	}
 
-	if len(users) == 0 {
-		sendMessage(bot, chatID, "📂 Tidak ada user saat ini.")
+	if total == 0 {
+		if action == "search" {
+			sendMessage(bot, chatID, "🔎 User tidak ditemukan.")
+		} else {
+			sendMessage(bot, chatID, "📂 Tidak ada user saat ini.")
+		}
 		showMainMenu(bot, chatID)
 		return
 	}
 
-	perPage := 10
-	totalPages := (len(users) + perPage - 1) / perPage
-	if page < 1 {
-		page = 1
-	}
-	if page > totalPages {
-		page = totalPages
-	}
-
-	start := (page - 1) * perPage
-	end := start + perPage
-	if end > len(users) {
-		end = len(users)
-	}
+	totalPages := (total + perPage - 1) / perPage
 
 	var rows [][]tgbotapi.InlineKeyboardButton
-	for _, u := range users[start:end] {
+	for _, u := range users {
 		statusIcon := "🟢"
 		if u.Status == "Expired" {
 			statusIcon = "🔴"
+		} else if u.Status == "Suspended" {
+			statusIcon = "⏸️"
 		}
-		label := fmt.Sprintf("%s %s (%s)", statusIcon, u.Password, u.Expired)
+		label := fmt.Sprintf("%s %s (%s)", statusIcon, u.Username, formatExpiry(u.Expired))
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("select_%s:%s", action, u.Password)),
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("select_%s:%s", action, u.Username)),
 		))
 	}
 
@@ -645,11 +990,22 @@ func showUserSelection(bot *tgbotapi.BotAPI, chatID int64, page int, action stri
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Menu", "cancel")))
 
 	title := "🗑️ HAPUS"
-	if action == "renew" {
+	switch action {
+	case "renew":
 		title = "🔄 RENEW"
-	}
-
-	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("*%s*\nHalaman %d/%d", title, page, totalPages))
+	case "rename":
+		title = "🔐 GANTI PASSWORD"
+	case "link":
+		title = "📱 LINK TELEGRAM"
+	case "suspend":
+		title = "⏸️ SUSPEND"
+	case "unsuspend":
+		title = "▶️ UNSUSPEND"
+	case "search":
+		title = "🔎 HASIL PENCARIAN"
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("*%s*\nHalaman %d/%d (%d user)", title, page, totalPages, total))
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 	sendAndTrack(bot, msg)
@@ -679,8 +1035,8 @@ func showMainMenu(bot *tgbotapi.BotAPI, chatID int64) {
 	}
 
 	totalUsers := 0
-	if users, err := getUsers(); err == nil {
-		totalUsers = len(users)
+	if _, total, _, err := fetchUsers(url.Values{"limit": {"1"}}); err == nil {
+		totalUsers = total
 	}
 
 	var notifStatus string
@@ -702,7 +1058,7 @@ func showMainMenu(bot *tgbotapi.BotAPI, chatID int64) {
 	// --- HITUNG MUNDUR VPS ---
 	vpsInfo := "⚠️ Belum diatur"
 	if config.VpsExpiredDate != "" {
-		expDate, err := time.Parse("2006-01-02", config.VpsExpiredDate)
+		expDate, err := time.ParseInLocation("2006-01-02", config.VpsExpiredDate, displayLoc)
 		if err == nil {
 			diff := time.Until(expDate)
 			daysLeft := int(diff.Hours() / 24)
@@ -740,6 +1096,14 @@ func showMainMenu(bot *tgbotapi.BotAPI, chatID int64) {
 			tgbotapi.NewInlineKeyboardButtonData("🔄 Renew Akun", "menu_renew"),
 			tgbotapi.NewInlineKeyboardButtonData("🗑️ Delete Akun", "menu_delete"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔐 Ganti Password", "menu_rename"),
+			tgbotapi.NewInlineKeyboardButtonData("📱 Link Telegram", "menu_link"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏸️ Suspend Akun", "menu_suspend"),
+			tgbotapi.NewInlineKeyboardButtonData("▶️ Unsuspend Akun", "menu_unsuspend"),
+		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("📋 List Akun", "menu_list"),
 			tgbotapi.NewInlineKeyboardButtonData("📊 Info Server", "menu_info"),
@@ -757,6 +1121,14 @@ func showMainMenu(bot *tgbotapi.BotAPI, chatID int64) {
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("🗑️ Hapus Expired & Restart", "menu_clean_restart"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔍 Cek Sinkron", "menu_reconcile"),
+			tgbotapi.NewInlineKeyboardButtonData("🚫 Pelanggaran IP", "menu_violations"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔎 Cari User", "menu_search"),
+			tgbotapi.NewInlineKeyboardButtonData("🤝 Reseller", "menu_resellers"),
+		),
 	)
 
 	photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileURL(MenuPhotoURL))
@@ -832,6 +1204,23 @@ func sendAndTrack(bot *tgbotapi.BotAPI, msg tgbotapi.MessageConfig) {
 	}
 }
 
+// validUsername mengikuti aturan username di API agar kesalahan input
+// langsung terlihat sebelum admin mengisi data lainnya.
+func validUsername(username string) bool {
+	if len(username) < 3 || len(username) > 32 {
+		return false
+	}
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_' || c == '-' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func generateRandomPassword(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, length)
@@ -942,7 +1331,7 @@ func performAutoBackup(bot *tgbotapi.BotAPI, adminID int64) {
 
 	doc := tgbotapi.NewDocument(adminID, tgbotapi.FilePath(filePath))
 	doc.Caption = fmt.Sprintf("💾 *AUTO BACKUP REPORT*\n📅 Waktu: `%s`\n📁 Ukuran: %.2f MB\n📂 Lokasi: `%s`",
-		getNowWIB().Format("2006-01-02 15:04:05"),
+		getNowLocal().Format("2006-01-02 15:04:05"),
 		float64(fileInfo.Size())/1024/1024,
 		filePath)
 	doc.ParseMode = "Markdown"
@@ -986,7 +1375,7 @@ func performManualBackup(bot *tgbotapi.BotAPI, chatID int64) {
 
 	doc := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(filePath))
 	doc.Caption = fmt.Sprintf("💾 *Backup Data User (Waktu: %s)*\n📁 Ukuran: %.2f MB\n📂 Lokasi: `%s`",
-		getNowWIB().Format("2006-01-02 15:04:05"),
+		getNowLocal().Format("2006-01-02 15:04:05"),
 		float64(fileInfo.Size())/1024/1024,
 		filePath)
 	doc.ParseMode = "Markdown"
@@ -1015,11 +1404,27 @@ func performManualBackup(bot *tgbotapi.BotAPI, chatID int64) {
 
 // --- SYSTEM & USER MANAGEMENT FUNCTIONS ---
 
+// cleanAndRestartService meminta API menghapus semua akun expired tanpa
+// menunggu masa tenggang. API merestart service jika ada akun yang dihapus.
 func cleanAndRestartService(bot *tgbotapi.BotAPI, chatID int64) {
 	sendMessage(bot, chatID, "🧹 Membersihkan akun expired & Restart Service...")
 
 	go func() {
-		autoDeleteExpiredUsers(bot, chatID, true)
+		res, err := apiCall("POST", "/users/purge-expired", nil)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ Error API: "+err.Error()))
+			return
+		}
+		if res["success"] != true {
+			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ Gagal membersihkan akun expired: %v", res["message"])))
+			return
+		}
+		purged, _ := res["data"].([]interface{})
+		if len(purged) == 0 {
+			bot.Send(tgbotapi.NewMessage(chatID, "✅ Tidak ada akun kadaluwarsa, service tidak perlu di-restart."))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🔄 %d akun kadaluwarsa dihapus & restart service %s dijadwalkan.", len(purged), ServiceName)))
 	}()
 }
 
@@ -1028,110 +1433,713 @@ func restartVpnService() error {
 	return cmd.Run()
 }
 
-func autoDeleteExpiredUsers(bot *tgbotapi.BotAPI, adminID int64, shouldRestart bool) {
+// parseThreshold membaca threshold pengingat seperti "3d" atau "1h".
+func parseThreshold(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// userExpiry mengikuti aturan API: expired disimpan dalam RFC3339, format
+// lama tanpa zona dibaca dalam waktu lokal server dan tanggal tanpa jam
+// berlaku sampai akhir hari tersebut.
+func userExpiry(expired string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, expired); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", expired, time.Local); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", expired, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), true
+	}
+	return time.Time{}, false
+}
+
+// formatExpiry menampilkan expired dalam zona waktu tampilan, contoh
+// "2024-12-31 23:59 WIB".
+func formatExpiry(expired string) string {
+	t, ok := userExpiry(expired)
+	if !ok {
+		return expired
+	}
+	return t.In(displayLoc).Format("2006-01-02 15:04 MST")
+}
+
+func loadReminderState() map[string]string {
+	sent := make(map[string]string)
+	if data, err := os.ReadFile(ReminderStateFile); err == nil {
+		json.Unmarshal(data, &sent)
+	}
+	return sent
+}
+
+func saveReminderState(sent map[string]string) error {
+	data, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ReminderStateFile, data, 0644)
+}
+
+// writeFileAtomic menulis ke file sementara di direktori yang sama lalu
+// me-rename-nya, sama seperti di API, agar crash saat menulis tidak
+// meninggalkan file setengah jadi.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// fsync direktori agar rename ikut tersimpan di disk
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// sendExpiryReminders mengirim pengingat ke admin dan, jika user punya
+// telegram_id, langsung ke pelanggan. Setiap threshold hanya dikirim sekali
+// per periode expired: key memuat tanggal expired sehingga renew memulai
+// siklus pengingat baru.
+func sendExpiryReminders(bot *tgbotapi.BotAPI, adminID int64) {
+	config, err := loadConfig()
+	if err != nil {
+		return
+	}
+	names := config.ReminderThresholds
+	if len(names) == 0 {
+		names = DefaultReminderThresholds
+	}
+	type threshold struct {
+		name string
+		dur  time.Duration
+	}
+	var thresholds []threshold
+	for _, n := range names {
+		d, err := parseThreshold(n)
+		if err != nil || d <= 0 {
+			log.Printf("⚠️ [Reminder] Threshold tidak valid: %q", n)
+			continue
+		}
+		thresholds = append(thresholds, threshold{n, d})
+	}
+
 	users, err := getUsers()
 	if err != nil {
-		log.Printf("❌ [AutoDelete] Gagal mengambil data user: %v", err)
+		log.Printf("❌ [Reminder] Gagal mengambil data user: %v", err)
 		return
 	}
 
-	deletedCount := 0
-	var deletedUsers []string
+	sent := loadReminderState()
+	current := make(map[string]string)
+	changed := false
+	now := time.Now()
 
 	for _, u := range users {
-		// 1. Parse tanggal expired dari string ke Time object
-		expiredTime, err := time.Parse("2006-01-02", u.Expired)
-		if err != nil {
-			// Coba format dengan jam jika format tanggal saja gagal
-			expiredTime, err = time.Parse("2006-01-02 15:04:05", u.Expired)
-			if err != nil {
-				// Jika format tanggal kacau, skip user ini
+		if u.Status != "Active" {
+			continue
+		}
+		exp, ok := userExpiry(u.Expired)
+		if !ok || !exp.After(now) {
+			continue
+		}
+		remaining := exp.Sub(now)
+
+		// Hanya threshold terdekat yang dikirim; threshold yang lebih besar
+		// ikut ditandai agar akun berdurasi pendek tidak menerima semuanya.
+		var due *threshold
+		for i := range thresholds {
+			t := thresholds[i]
+			if remaining > t.dur {
 				continue
 			}
-		}
-
-		// 2. Logika Utama: Cek apakah waktu SEKARANG sudah melebihi waktu EXPIRED
-		if time.Now().After(expiredTime) {
-
-			// Lakukan penghapusan via API
-			res, err := apiCall("POST", "/user/delete", map[string]interface{}{
-				"password": u.Password,
-			})
-
-			if err != nil {
-				log.Printf("❌ [AutoDelete] Error API saat menghapus %s: %v", u.Password, err)
+			// Kunci memakai UTC agar ganti timezone tidak mengirim ulang pengingat
+			key := u.Username + "|" + exp.UTC().Format(time.RFC3339) + "|" + t.name
+			if at, ok := sent[key]; ok {
+				current[key] = at
 				continue
 			}
-
-			if res["success"] == true {
-				deletedCount++
-				deletedUsers = append(deletedUsers, u.Password)
-				log.Printf("✅ [AutoDelete] User kadaluwarsa [%s] (Exp: %s) berhasil dihapus.", u.Password, u.Expired)
-			} else {
-				log.Printf("❌ [AutoDelete] Gagal menghapus %s: %s", u.Password, res["message"])
+			current[key] = now.Format(time.RFC3339)
+			changed = true
+			if due == nil || t.dur < due.dur {
+				due = &thresholds[i]
 			}
 		}
-	}
+		if due == nil || bot == nil {
+			continue
+		}
 
-	// --- Logika Restart Service (Opsional, hanya jika diminta via menu manual) ---
-	if shouldRestart {
-		if deletedCount > 0 {
-			log.Printf("🔄 [Restart Service] Melakukan restart service %s...", ServiceName)
-			if err := restartVpnService(); err != nil {
-				log.Printf("❌ Gagal restart service: %v", err)
-				if bot != nil {
-					bot.Send(tgbotapi.NewMessage(adminID, "❌ Gagal merestart service. Cek log server."))
-				}
-			} else {
-				log.Printf("✅ Service %s berhasil di-restart.", ServiceName)
-				if bot != nil {
-					bot.Send(tgbotapi.NewMessage(adminID, fmt.Sprintf("🔄 %d akun kadaluwarsa dihapus & Service %s berhasil di-restart.", deletedCount, ServiceName)))
-				}
-			}
-		} else {
-			if bot != nil {
-				bot.Send(tgbotapi.NewMessage(adminID, "✅ Tidak ada akun kadaluwarsa. Tidak perlu restart service."))
+		left := formatRemaining(remaining)
+		adminMsg := tgbotapi.NewMessage(adminID, fmt.Sprintf("⏰ *PENGINGAT EXPIRED*\n\n"+
+			"User `%s` akan expired dalam *%s*.\n"+
+			"🗓️ *Expired*: `%s`", u.Username, left, formatExpiry(u.Expired)))
+		adminMsg.ParseMode = "Markdown"
+		bot.Send(adminMsg)
+
+		if u.TelegramID != 0 {
+			customerMsg := tgbotapi.NewMessage(u.TelegramID, fmt.Sprintf("⏰ *PENGINGAT MASA AKTIF*\n\n"+
+				"Akun VPN `%s` akan berakhir dalam *%s* (`%s`).\n"+
+				"Silakan hubungi admin untuk perpanjangan.", u.Username, left, formatExpiry(u.Expired)))
+			customerMsg.ParseMode = "Markdown"
+			if _, err := bot.Send(customerMsg); err != nil {
+				log.Printf("❌ [Reminder] Gagal mengirim ke pelanggan %s (%d): %v", u.Username, u.TelegramID, err)
 			}
 		}
-		return
 	}
 
-	// --- Notifikasi Admin (Hanya jika ada yang dihapus pada background loop) ---
-	if deletedCount > 0 {
-		if bot != nil {
-			// Batasi pesan agar tidak spam jika terlalu banyak user yang dihapus sekaligus
-			userListStr := strings.Join(deletedUsers, ", ")
-			if len(userListStr) > 500 {
-				userListStr = userListStr[:500] + "..."
-			}
+	// Catatan untuk user yang sudah dihapus atau di-renew tidak disimpan lagi
+	if changed || len(current) != len(sent) {
+		if err := saveReminderState(current); err != nil {
+			log.Printf("❌ [Reminder] Gagal menyimpan status pengingat: %v", err)
+		}
+	}
+}
 
-			msgText := fmt.Sprintf("🗑️ *AUTO DELETE EXPIRED*\n\n"+
-				"Bot telah menghapus `%d` akun yang tanggalnya sudah lewat:\n- %s",
-				deletedCount, userListStr)
+func formatRemaining(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%d hari %d jam", int(d.Hours())/24, int(d.Hours())%24)
+	}
+	if d >= time.Hour {
+		return fmt.Sprintf("%d jam %d menit", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%d menit", int(d.Minutes()))
+}
 
-			notification := tgbotapi.NewMessage(adminID, msgText)
-			notification.ParseMode = "Markdown"
-			bot.Send(notification)
+// seedEventCursor melewati event lama agar bot yang baru start tidak
+// mengirim ulang notifikasi. API bisa belum siap saat bot start, jadi
+// dicoba ulang sampai berhasil sebelum stream dibuka.
+func seedEventCursor() {
+	var lastID int64
+	for {
+		var err error
+		if _, lastID, err = fetchEvents(0, 1); err == nil {
+			break
 		}
+		log.Printf("❌ [Event] Gagal membaca event API, dicoba lagi: %v", err)
+		time.Sleep(EventRetryDelay)
 	}
+	stateMutex.Lock()
+	eventCursor = lastID
+	stateMutex.Unlock()
+}
+
+func fetchEvents(after int64, limit int) ([]ApiEvent, int64, error) {
+	res, err := apiCall("GET", fmt.Sprintf("/events?after=%d&limit=%d", after, limit), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res["success"] != true {
+		return nil, 0, fmt.Errorf("%v", res["message"])
+	}
+	var data struct {
+		Events []ApiEvent `json:"events"`
+		LastID int64      `json:"last_id"`
+	}
+	raw, _ := json.Marshal(res["data"])
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, 0, err
+	}
+	return data.Events, data.LastID, nil
+}
+
+// subscribeEvents berlangganan stream SSE /api/events dan menyambung ulang
+// dari event terakhir yang diterima jika koneksi terputus.
+func subscribeEvents(bot *tgbotapi.BotAPI, adminID int64) {
+	incoming := make(chan ApiEvent, 100)
+	go func() {
+		for {
+			if err := readEventStream(incoming); err != nil {
+				log.Printf("❌ [Event] Stream event terputus: %v", err)
+			}
+			time.Sleep(EventRetryDelay)
+		}
+	}()
+
+	for e := range incoming {
+		batch := []ApiEvent{e}
+		timeout := time.After(EventBatchDelay)
+	collect:
+		for {
+			select {
+			case e := <-incoming:
+				batch = append(batch, e)
+			case <-timeout:
+				break collect
+			}
+		}
+		notifyEvents(bot, adminID, batch)
+	}
+}
+
+// readEventStream membaca satu koneksi stream sampai terputus.
+func readEventStream(out chan<- ApiEvent) error {
+	req, err := http.NewRequest("GET", ApiUrl+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-API-Key", ApiKey)
+	// Tanpa cursor header tidak dikirim sehingga API mulai dari event
+	// terakhir, bukan memutar ulang seluruh log
+	stateMutex.RLock()
+	if eventCursor > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(eventCursor, 10))
+	}
+	stateMutex.RUnlock()
+
+	// Tanpa timeout: koneksi stream memang dibiarkan terbuka
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status: %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var e ApiEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &e); err != nil {
+			log.Printf("❌ [Event] Event tidak valid: %v", err)
+			continue
+		}
+		stateMutex.Lock()
+		eventCursor = e.ID
+		stateMutex.Unlock()
+		out <- e
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// notifyEvents mengirim notifikasi ke admin untuk akun expired yang
+// dinonaktifkan/dihapus dan suspend karena kuota.
+func notifyEvents(bot *tgbotapi.BotAPI, adminID int64, list []ApiEvent) {
+	var disabled, purged []string
+	purgeInfo := ""
+	for _, e := range list {
+		switch {
+		case e.Type == "user.expired":
+			disabled = append(disabled, e.Username)
+			if at, ok := e.Data["purge_at"].(string); ok {
+				purgeInfo = "Akun dihapus otomatis setelah masa tenggang (mulai " + formatExpiry(at) + ")."
+			} else {
+				purgeInfo = "Akun tidak dihapus otomatis (purge_policy: keep)."
+			}
+		case e.Type == "service.restart_failed":
+			notification := tgbotapi.NewMessage(adminID, fmt.Sprintf("⚠️ *RESTART SERVICE GAGAL*\n\n"+
+				"Perubahan user sudah tersimpan, tetapi %v service `%s` gagal:\n`%v`",
+				e.Data["method"], ServiceName, e.Data["error"]))
+			notification.ParseMode = "Markdown"
+			bot.Send(notification)
+		case e.Type == "user.purged":
+			purged = append(purged, e.Username)
+		case e.Type == "user.suspended" && e.Data["reason"] == "quota":
+			usage, _ := e.Data["usage_bytes"].(float64)
+			msgText := fmt.Sprintf("⏸️ *KUOTA HABIS*\n\n"+
+				"User `%s` otomatis di-suspend.\n"+
+				"💾 *Pemakaian*: `%.2f GB` dari `%v GB`\n\n"+
+				"_Renew atau tambah limit kuota untuk mengaktifkan kembali._",
+				e.Username, usage/(1024*1024*1024), e.Data["limit_quota"])
+			notification := tgbotapi.NewMessage(adminID, msgText)
+			notification.ParseMode = "Markdown"
+			bot.Send(notification)
+		}
+	}
+
+	// Batasi pesan agar tidak spam jika terlalu banyak user sekaligus
+	joinUsers := func(names []string) string {
+		str := strings.Join(names, ", ")
+		if len(str) > 500 {
+			str = str[:500] + "..."
+		}
+		return str
+	}
+	if len(disabled) > 0 {
+		notification := tgbotapi.NewMessage(adminID, fmt.Sprintf("⏸️ *AKUN EXPIRED DINONAKTIFKAN*\n\n"+
+			"`%d` akun dinonaktifkan:\n- %s\n\n%s\n_Renew untuk mengaktifkan kembali dengan data lama._",
+			len(disabled), joinUsers(disabled), purgeInfo))
+		notification.ParseMode = "Markdown"
+		bot.Send(notification)
+	}
+	if len(purged) > 0 {
+		notification := tgbotapi.NewMessage(adminID, fmt.Sprintf("🗑️ *AUTO DELETE EXPIRED*\n\n"+
+			"API telah menghapus `%d` akun yang masa tenggangnya sudah lewat:\n- %s",
+			len(purged), joinUsers(purged)))
+		notification.ParseMode = "Markdown"
+		bot.Send(notification)
+	}
+}
+
+// showReconcile menampilkan selisih antara password di config.json dan
+// users.db (dry-run) beserta tombol untuk memperbaikinya.
+func showReconcile(bot *tgbotapi.BotAPI, chatID int64) {
+	res, err := apiCall("GET", "/reconcile", nil)
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		sendMessage(bot, chatID, "❌ Gagal mengecek sinkronisasi.")
+		return
+	}
+
+	data, _ := res["data"].(map[string]interface{})
+	configOnly, _ := data["config_only"].([]interface{})
+	dbOnly, _ := data["db_only"].([]interface{})
+
+	if len(configOnly) == 0 && len(dbOnly) == 0 {
+		sendMessage(bot, chatID, "✅ Config dan database user sudah sinkron.")
+		showMainMenu(bot, chatID)
+		return
+	}
+
+	msgText := "🔍 *CEK SINKRON CONFIG & DB*\n\n"
+	msgText += fmt.Sprintf("📄 *Hanya di config.json* (%d):\n%s\n", len(configOnly), formatReconcileList(configOnly))
+	msgText += fmt.Sprintf("🗄️ *Hanya di users.db* (%d):\n%s\n", len(dbOnly), formatReconcileList(dbOnly))
+	msgText += "*Perbaiki*: password di config dicatat ke DB dengan masa aktif yang dimasukkan, record DB diaktifkan di config.\n" +
+		"*Hapus*: semua password/record yang tidak berpasangan dihapus."
+
+	msg := tgbotapi.NewMessage(chatID, msgText)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Perbaiki", "reconcile_fix"),
+			tgbotapi.NewInlineKeyboardButtonData("🗑️ Hapus", "reconcile_clean"),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Batal", "cancel")),
+	)
+	sendAndTrack(bot, msg)
+}
+
+// showViolations menampilkan pelanggaran limit IP terbaru yang dicatat API.
+func showViolations(bot *tgbotapi.BotAPI, chatID int64) {
+	res, err := apiCall("GET", "/violations?limit=10", nil)
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		sendMessage(bot, chatID, "❌ Gagal mengambil data pelanggaran.")
+		return
+	}
+
+	items, _ := res["data"].([]interface{})
+	if len(items) == 0 {
+		sendMessage(bot, chatID, "✅ Belum ada pelanggaran limit IP.")
+		showMainMenu(bot, chatID)
+		return
+	}
+
+	msgText := "🚫 *PELANGGARAN LIMIT IP* (10 terbaru)\n\n"
+	for i, it := range items {
+		v, ok := it.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		action := "ditolak"
+		if v["action"] == "suspend" {
+			action = "di-suspend"
+		}
+		msgText += fmt.Sprintf("%d. `%v` (limit %v)\n    IP baru `%v` %s\n    _%v_\n", i+1, v["username"], v["limit_ip"], v["ip"], action, v["time"])
+	}
+
+	reply := tgbotapi.NewMessage(chatID, msgText)
+	reply.ParseMode = "Markdown"
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Menu", "cancel")),
+	)
+	sendAndTrack(bot, reply)
+}
+
+func formatReconcileList(items []interface{}) string {
+	if len(items) == 0 {
+		return "- _tidak ada_\n"
+	}
+	// Dipotong per baris agar backtick Markdown tidak terbelah
+	var sb strings.Builder
+	for i, it := range items {
+		line := fmt.Sprintf("- `%v`\n", it)
+		if sb.Len()+len(line) > 1000 {
+			sb.WriteString(fmt.Sprintf("... dan %d lainnya\n", len(items)-i))
+			break
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// applyReconcile menerapkan rekonsiliasi. days adalah masa aktif record
+// hasil import dan diabaikan untuk aksi lain.
+func applyReconcile(bot *tgbotapi.BotAPI, chatID int64, configOnly, dbOnly string, days int) {
+	payload := map[string]interface{}{
+		"dry_run":     false,
+		"config_only": configOnly,
+		"db_only":     dbOnly,
+	}
+	if configOnly == "import" {
+		payload["days"] = days
+	}
+	res, err := apiCall("POST", "/reconcile", payload)
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		errMsg, ok := res["message"].(string)
+		if !ok {
+			errMsg = "Pesan error tidak diketahui dari API."
+		}
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal sinkronisasi: %s", errMsg))
+		showMainMenu(bot, chatID)
+		return
+	}
+
+	reply := tgbotapi.NewMessage(chatID, "✅ Config dan database user berhasil disinkronkan.")
+	deleteLastMessage(bot, chatID)
+	bot.Send(reply)
+	showMainMenu(bot, chatID)
+}
+
+// showResellers menampilkan semua reseller beserta saldo dan jumlah akunnya.
+func showResellers(bot *tgbotapi.BotAPI, chatID int64) {
+	res, err := apiCall("GET", "/resellers", nil)
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		sendMessage(bot, chatID, "❌ Gagal mengambil data reseller.")
+		return
+	}
+
+	items, _ := res["data"].([]interface{})
+	msgText := fmt.Sprintf("🤝 *DAFTAR RESELLER* (Total: %d)\n\n", len(items))
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, it := range items {
+		rs, ok := it.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		msgText += fmt.Sprintf("%d. *%v* (`%v`)\n    _Saldo: %v | Harga/hari: %v_\n    _Akun: %v / %v_\n",
+			i+1, rs["name"], rs["id"], rs["balance"], rs["price_per_day"], rs["accounts"], formatMaxAccounts(rs["max_accounts"]))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🤝 %v", rs["name"]), fmt.Sprintf("select_reseller:%v", rs["id"])),
+		))
+	}
+	if len(items) == 0 {
+		msgText += "_Belum ada reseller._\n"
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("➕ Tambah Reseller", "reseller_add")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Menu", "cancel")),
+	)
+
+	msg := tgbotapi.NewMessage(chatID, msgText)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sendAndTrack(bot, msg)
+}
+
+func showResellerDetail(bot *tgbotapi.BotAPI, chatID int64, id string) {
+	res, err := apiCall("GET", "/resellers", nil)
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	items, _ := res["data"].([]interface{})
+	for _, it := range items {
+		rs, ok := it.(map[string]interface{})
+		if !ok || rs["id"] != id {
+			continue
+		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("🤝 *RESELLER %v*\n"+
+			"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
+			"🆔 *ID*: `%v`\n"+
+			"💰 *Saldo*: `%v`\n"+
+			"🏷️ *Harga/Hari*: `%v`\n"+
+			"👥 *Akun*: `%v / %v`\n"+
+			"━━━━━━━━━━━━━━━━━━━━━━━━━",
+			rs["name"], rs["id"], rs["balance"], rs["price_per_day"], rs["accounts"], formatMaxAccounts(rs["max_accounts"])))
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("💰 Topup Saldo", "reseller_topup:"+id),
+				tgbotapi.NewInlineKeyboardButtonData("🗑️ Hapus", "reseller_delete:"+id),
+			),
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Kembali", "menu_resellers")),
+		)
+		sendAndTrack(bot, msg)
+		return
+	}
+	sendMessage(bot, chatID, "❌ Reseller tidak ditemukan.")
+	showResellers(bot, chatID)
+}
+
+func formatMaxAccounts(v interface{}) string {
+	if n, ok := v.(float64); ok && n > 0 {
+		return fmt.Sprintf("%.0f", n)
+	}
+	return "∞"
+}
+
+func createReseller(bot *tgbotapi.BotAPI, chatID int64, name string, balance, price, maxAccounts int) {
+	res, err := apiCall("POST", "/resellers/create", map[string]interface{}{
+		"name":          name,
+		"balance":       balance,
+		"price_per_day": price,
+		"max_accounts":  maxAccounts,
+	})
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		errMsg, ok := res["message"].(string)
+		if !ok {
+			errMsg = "Pesan error tidak diketahui dari API."
+		}
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal membuat reseller: %s", errMsg))
+		showResellers(bot, chatID)
+		return
+	}
+
+	data, _ := res["data"].(map[string]interface{})
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ *RESELLER BERHASIL DIBUAT*\n"+
+		"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
+		"🤝 *Nama*: `%s`\n"+
+		"💰 *Saldo*: `%d`\n"+
+		"🏷️ *Harga/Hari*: `%d`\n"+
+		"🔑 *API Key*: `%v`\n"+
+		"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
+		"_Simpan API key ini, key tidak akan ditampilkan lagi._",
+		name, balance, price, data["key"]))
+	msg.ParseMode = "Markdown"
+	deleteLastMessage(bot, chatID)
+	bot.Send(msg)
+	showMainMenu(bot, chatID)
+}
+
+func topupReseller(bot *tgbotapi.BotAPI, chatID int64, id string, amount int) {
+	res, err := apiCall("POST", "/resellers/topup", map[string]interface{}{
+		"id":     id,
+		"amount": amount,
+	})
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		errMsg, ok := res["message"].(string)
+		if !ok {
+			errMsg = "Pesan error tidak diketahui dari API."
+		}
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal topup: %s", errMsg))
+		showResellers(bot, chatID)
+		return
+	}
+
+	data, _ := res["data"].(map[string]interface{})
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Saldo reseller *%v* sekarang `%v`.", data["name"], data["balance"]))
+	msg.ParseMode = "Markdown"
+	deleteLastMessage(bot, chatID)
+	bot.Send(msg)
+	showResellers(bot, chatID)
+}
+
+func deleteReseller(bot *tgbotapi.BotAPI, chatID int64, id string) {
+	res, err := apiCall("POST", "/resellers/delete", map[string]interface{}{
+		"id": id,
+	})
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+	if res["success"] != true {
+		errMsg, ok := res["message"].(string)
+		if !ok {
+			errMsg = "Pesan error tidak diketahui dari API."
+		}
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal menghapus reseller: %s", errMsg))
+		showResellers(bot, chatID)
+		return
+	}
+	deleteLastMessage(bot, chatID)
+	bot.Send(tgbotapi.NewMessage(chatID, "✅ Reseller berhasil dihapus dan API key-nya dicabut."))
+	showResellers(bot, chatID)
 }
 
 func apiCall(method, endpoint string, payload interface{}) (map[string]interface{}, error) {
+	status, result, err := apiRequest(method, ApiUrl+endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("API returned status: %d", status)
+	}
+	return result, nil
+}
+
+// apiCallV2 memanggil /api/v2. Berbeda dengan apiCall, respon gagal tetap
+// dikembalikan agar field "code" bisa dibaca (lihat apiErrorText).
+func apiCallV2(method, endpoint string, payload interface{}) (map[string]interface{}, error) {
+	_, result, err := apiRequest(method, ApiUrl+"/v2"+endpoint, payload)
+	return result, err
+}
+
+func apiRequest(method, apiURL string, payload interface{}) (int, map[string]interface{}, error) {
 	var reqBody []byte
 	var err error
 
 	if payload != nil {
 		reqBody, err = json.Marshal(payload)
 		if err != nil {
-			return nil, err
+			return 0, nil, err
 		}
 	}
 
 	client := &http.Client{Timeout: 10 * time.Second}
 
-	req, err := http.NewRequest(method, ApiUrl+endpoint, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest(method, apiURL, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -1139,21 +2147,57 @@ func apiCall(method, endpoint string, payload interface{}) (map[string]interface
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %d", resp.StatusCode)
+	// 204 No Content (misalnya DELETE di v2) tidak punya body
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, map[string]interface{}{"success": true}, nil
 	}
 
 	body, _ := io.ReadAll(resp.Body)
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to decode API response: %v", err)
+		return resp.StatusCode, nil, fmt.Errorf("failed to decode API response (status %d): %v", resp.StatusCode, err)
 	}
 
-	return result, nil
+	return resp.StatusCode, result, nil
+}
+
+// apiErrorText memilih pesan untuk admin berdasarkan kode error API.
+// Kode yang tidak dikenal memakai pesan dari API apa adanya.
+func apiErrorText(res map[string]interface{}) string {
+	switch res["code"] {
+	case "user_exists":
+		return "Password sudah dipakai user lain."
+	case "username_taken":
+		return "Username sudah dipakai."
+	case "password_taken":
+		return "Password baru sudah dipakai user lain."
+	case "user_not_found":
+		return "User tidak ditemukan, mungkin sudah dihapus."
+	case "already_suspended":
+		return "User sudah dalam status suspend."
+	case "not_suspended":
+		return "User tidak dalam status suspend."
+	}
+	if msg, ok := res["message"].(string); ok && msg != "" {
+		return msg
+	}
+	return "Pesan error tidak diketahui dari API."
+}
+
+// serverDomain membaca domain server dari /api/info.
+func serverDomain() string {
+	if res, err := apiCall("GET", "/info", nil); err == nil && res["success"] == true {
+		if data, ok := res["data"].(map[string]interface{}); ok {
+			if d, ok := data["domain"].(string); ok && d != "" {
+				return d
+			}
+		}
+	}
+	return "Unknown"
 }
 
 func getIpInfo() (IpInfo, error) {
@@ -1196,10 +2240,174 @@ func getUsers() ([]UserData, error) {
 	return users, nil
 }
 
-func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, duration string, limitIP int, limitQuota int, config BotConfig) {
+// fetchUsers memanggil GET /v2/users dengan filter dan pagination dari API.
+func fetchUsers(query url.Values) ([]UserData, int, string, error) {
+	res, err := apiCallV2("GET", "/users?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if res["success"] != true {
+		return nil, 0, "", fmt.Errorf("%s", apiErrorText(res))
+	}
+
+	var page struct {
+		Users      []UserData `json:"users"`
+		Total      int        `json:"total"`
+		NextCursor string     `json:"next_cursor"`
+	}
+	dataBytes, err := json.Marshal(res["data"])
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("gagal marshal data: %v", err)
+	}
+	if err := json.Unmarshal(dataBytes, &page); err != nil {
+		return nil, 0, "", fmt.Errorf("gagal unmarshal data ke UserData: %v", err)
+	}
+	return page.Users, page.Total, page.NextCursor, nil
+}
+
+// userListView menyimpan filter dan cursor halaman daftar user per chat.
+// Cursor dari API terlalu panjang untuk callback data Telegram (maksimal
+// 64 byte), jadi tombol navigasi hanya membawa nomor halaman.
+type userListView struct {
+	Action  string
+	Query   url.Values
+	Cursors []string // Cursors[i] adalah cursor untuk halaman i+1
+}
+
+func openUserView(chatID int64, action string, query url.Values) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	userViews[chatID] = &userListView{Action: action, Query: query, Cursors: []string{""}}
+}
+
+// loadUserPage mengambil halaman dari view aktif di chat. Halaman yang
+// cursornya belum diketahui (misalnya setelah bot restart) diganti dengan
+// halaman terjauh yang sudah diketahui.
+func loadUserPage(chatID int64, action string, page, perPage int, defaults url.Values) ([]UserData, int, int, error) {
+	stateMutex.Lock()
+	view, ok := userViews[chatID]
+	if !ok || view.Action != action {
+		view = &userListView{Action: action, Query: defaults, Cursors: []string{""}}
+		userViews[chatID] = view
+	}
+	if page > len(view.Cursors) {
+		page = len(view.Cursors)
+	}
+	if page < 1 {
+		page = 1
+	}
+	query := url.Values{}
+	for k, v := range view.Query {
+		query[k] = v
+	}
+	query.Set("limit", strconv.Itoa(perPage))
+	if cursor := view.Cursors[page-1]; cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	stateMutex.Unlock()
+
+	users, total, next, err := fetchUsers(query)
+	if err != nil {
+		return nil, 0, page, err
+	}
+
+	stateMutex.Lock()
+	if next != "" {
+		if len(view.Cursors) > page {
+			view.Cursors[page] = next
+		} else {
+			view.Cursors = append(view.Cursors, next)
+		}
+	}
+	stateMutex.Unlock()
+	return users, total, page, nil
+}
+
+func showListFilters(bot *tgbotapi.BotAPI, chatID int64) {
+	msg := tgbotapi.NewMessage(chatID, "📋 *LIST AKUN*\nPilih filter:")
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📋 Semua", "list_all"),
+			tgbotapi.NewInlineKeyboardButtonData("🟢 Aktif", "list_active"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔴 Expired", "list_expired"),
+			tgbotapi.NewInlineKeyboardButtonData("⏸️ Suspended", "list_suspended"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏳ Habis ≤ 3 Hari", "list_expiring"),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Menu", "cancel")),
+	)
+	sendAndTrack(bot, msg)
+}
+
+// listFilterQuery menerjemahkan tombol filter list ke query /users.
+func listFilterQuery(filter string) url.Values {
+	switch filter {
+	case "active", "expired", "suspended":
+		return url.Values{"status": {filter}, "sort": {"expiry"}}
+	case "expiring":
+		return url.Values{"expiring_within": {"3d"}, "sort": {"expiry"}}
+	}
+	return url.Values{}
+}
+
+// showUserActions menampilkan detail user hasil pencarian beserta tombol
+// aksi yang memakai callback select_* yang sama dengan menu utama.
+func showUserActions(bot *tgbotapi.BotAPI, chatID int64, username string) {
+	res, err := apiCallV2("GET", "/users/"+url.PathEscape(username), nil)
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Gagal mengambil data user.")
+		return
+	}
+	if res["success"] != true {
+		sendMessage(bot, chatID, "❌ "+apiErrorText(res))
+		showMainMenu(bot, chatID)
+		return
+	}
+	user := &UserData{}
+	dataBytes, _ := json.Marshal(res["data"])
+	if err := json.Unmarshal(dataBytes, user); err != nil {
+		sendMessage(bot, chatID, "❌ Format data user salah.")
+		return
+	}
+
+	text := fmt.Sprintf("👤 *DETAIL USER*\nUsername: `%s`\nPassword: `%s`\nStatus: %s\nKadaluarsa: %s\nLimit: %d IP / %d GB\nPemakaian: %.2f GB",
+		user.Username, user.Password, user.Status, formatExpiry(user.Expired), user.LimitIP, user.LimitQuota, float64(user.UsageBytes)/(1024*1024*1024))
+	if user.Reason != "" {
+		text += fmt.Sprintf("\nAlasan Suspend: %s", user.Reason)
+	}
+
+	toggle := tgbotapi.NewInlineKeyboardButtonData("⏸️ Suspend", "select_suspend:"+user.Username)
+	if user.Status == "Suspended" {
+		toggle = tgbotapi.NewInlineKeyboardButtonData("▶️ Unsuspend", "select_unsuspend:"+user.Username)
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Renew", "select_renew:"+user.Username),
+			tgbotapi.NewInlineKeyboardButtonData("🗑️ Hapus", "select_delete:"+user.Username),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔐 Ganti Password", "select_rename:"+user.Username),
+			toggle,
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📱 Link Telegram", "select_link:"+user.Username),
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Menu", "cancel"),
+		),
+	)
+	sendAndTrack(bot, msg)
+}
+
+func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, password string, days int, duration string, limitIP int, limitQuota int, config BotConfig) {
 	// Build payload: prefer explicit duration string if provided, otherwise use days
 	payload := map[string]interface{}{
-		"password":    username,
+		"username":    username,
+		"password":    password,
 		"limit_ip":    limitIP,
 		"limit_quota": limitQuota,
 	}
@@ -1209,7 +2417,7 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 		payload["duration"] = duration
 	}
 
-	res, err := apiCall("POST", "/user/create", payload)
+	res, err := apiCallV2("POST", "/users", payload)
 
 	if err != nil {
 		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
@@ -1224,6 +2432,7 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 		}
 
 		ipInfo, _ := getIpInfo()
+		domain := serverDomain()
 
 		title := "🎉 *AKUN BERHASIL DIBUAT*"
 		if days > 0 {
@@ -1251,6 +2460,7 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 		// Pesan untuk Admin (Full Detail)
 		msg := fmt.Sprintf("%s\n"+
 			"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
+			"👤 *Username*: `%s`\n"+
 			"🔑 *Password*: `%s`\n"+
 			"🌐 *Domain*: `%s`\n"+
 			"🗓️ *Expired*: `%s`\n"+
@@ -1262,7 +2472,7 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 			"🔒 *Private Tidak Digunakan User Lain*\n"+
 			"⚡ *Full Speed Anti Lemot Stabil 24 Jam*\n"+
 			"━━━━━━━━━━━━━━━━━━━━━━━━━",
-			title, data["password"], data["domain"], data["expired"], limitIP, limitQuota, ipInfo.City, ipInfo.Isp)
+			title, data["username"], data["password"], domain, formatExpiry(fmt.Sprint(data["expired"])), limitIP, limitQuota, ipInfo.City, ipInfo.Isp)
 
 		// Kirim ke Admin
 		reply := tgbotapi.NewMessage(chatID, msg)
@@ -1274,11 +2484,10 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 		if config.NotifGroupID != 0 {
 			// Ambil password dan domain asli untuk disensor
 			passStr, _ := data["password"].(string)
-			domStr, _ := data["domain"].(string)
 
 			// Fungsi sensor: Ganti karakter dengan bintang
 			maskedPass := strings.Repeat("*", len(passStr))
-			maskedDomain := strings.Repeat("*", len(domStr))
+			maskedDomain := strings.Repeat("*", len(domain))
 
 			groupMsg := fmt.Sprintf("%s\n"+
 				"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
@@ -1290,7 +2499,7 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 				"📍 *Lokasi Server*: `%s`\n"+
 				"📡 *ISP Server*: `%s`\n"+
 				"━━━━━━━━━━━━━━━━━━━━━━━━━\n",
-				title, maskedPass, maskedDomain, data["expired"], limitIP, limitQuota, ipInfo.City, ipInfo.Isp)
+				title, maskedPass, maskedDomain, formatExpiry(fmt.Sprint(data["expired"])), limitIP, limitQuota, ipInfo.City, ipInfo.Isp)
 
 			groupMsgObj := tgbotapi.NewMessage(config.NotifGroupID, groupMsg)
 			groupMsgObj.ParseMode = "Markdown"
@@ -1303,18 +2512,36 @@ func createUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, d
 
 		showMainMenu(bot, chatID)
 	} else {
-		errMsg, ok := res["message"].(string)
-		if !ok {
-			errMsg = "Pesan error tidak diketahui dari API."
-		}
+		errMsg := apiErrorText(res)
 		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal: %s", errMsg))
 		showMainMenu(bot, chatID)
 	}
 }
 
 func deleteUser(bot *tgbotapi.BotAPI, chatID int64, username string) {
-	res, err := apiCall("POST", "/user/delete", map[string]interface{}{
-		"password": username,
+	res, err := apiCallV2("DELETE", "/users/"+url.PathEscape(username), nil)
+
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+
+	if res["success"] == true {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ User `%s` berhasil *DIHAPUS*.", username))
+		msg.ParseMode = "Markdown"
+		deleteLastMessage(bot, chatID)
+		bot.Send(msg)
+		showMainMenu(bot, chatID)
+	} else {
+		errMsg := apiErrorText(res)
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal menghapus: %s", errMsg))
+		showMainMenu(bot, chatID)
+	}
+}
+
+func renamePassword(bot *tgbotapi.BotAPI, chatID int64, username string, newPassword string) {
+	res, err := apiCallV2("PATCH", "/users/"+url.PathEscape(username), map[string]interface{}{
+		"password": newPassword,
 	})
 
 	if err != nil {
@@ -1323,25 +2550,102 @@ func deleteUser(bot *tgbotapi.BotAPI, chatID int64, username string) {
 	}
 
 	if res["success"] == true {
-		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Password `%s` berhasil *DIHAPUS*.", username))
+		data, _ := res["data"].(map[string]interface{})
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("🔐 *PASSWORD BERHASIL DIGANTI*\n"+
+			"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
+			"👤 *Username*: `%s`\n"+
+			"🔑 *Password Baru*: `%s`\n"+
+			"🗓️ *Expired*: `%s`\n"+
+			"━━━━━━━━━━━━━━━━━━━━━━━━━",
+			username, newPassword, formatExpiry(fmt.Sprint(data["expired"]))))
 		msg.ParseMode = "Markdown"
 		deleteLastMessage(bot, chatID)
 		bot.Send(msg)
 		showMainMenu(bot, chatID)
 	} else {
-		errMsg, ok := res["message"].(string)
-		if !ok {
-			errMsg = "Pesan error tidak diketahui dari API."
+		errMsg := apiErrorText(res)
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal ganti password: %s", errMsg))
+		showMainMenu(bot, chatID)
+	}
+}
+
+func linkTelegram(bot *tgbotapi.BotAPI, chatID int64, username string, telegramID int64) {
+	res, err := apiCallV2("PATCH", "/users/"+url.PathEscape(username), map[string]interface{}{
+		"telegram_id": telegramID,
+	})
+
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+
+	if res["success"] == true {
+		text := fmt.Sprintf("📱 User `%s` terhubung ke chat ID `%d`. Pengingat expired akan dikirim ke pelanggan.", username, telegramID)
+		if telegramID == 0 {
+			text = fmt.Sprintf("📱 Chat Telegram user `%s` berhasil dilepas.", username)
 		}
-		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal menghapus: %s", errMsg))
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = "Markdown"
+		deleteLastMessage(bot, chatID)
+		bot.Send(msg)
+		showMainMenu(bot, chatID)
+	} else {
+		errMsg := apiErrorText(res)
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal link Telegram: %s", errMsg))
+		showMainMenu(bot, chatID)
+	}
+}
+
+func suspendUser(bot *tgbotapi.BotAPI, chatID int64, username string, reason string) {
+	res, err := apiCallV2("PATCH", "/users/"+url.PathEscape(username), map[string]interface{}{
+		"status": "suspended",
+		"reason": reason,
+	})
+
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+
+	if res["success"] == true {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("⏸️ User `%s` berhasil *DI-SUSPEND*.", username))
+		msg.ParseMode = "Markdown"
+		deleteLastMessage(bot, chatID)
+		bot.Send(msg)
+		showMainMenu(bot, chatID)
+	} else {
+		errMsg := apiErrorText(res)
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal suspend: %s", errMsg))
+		showMainMenu(bot, chatID)
+	}
+}
+
+func unsuspendUser(bot *tgbotapi.BotAPI, chatID int64, username string) {
+	res, err := apiCallV2("PATCH", "/users/"+url.PathEscape(username), map[string]interface{}{
+		"status": "active",
+	})
+
+	if err != nil {
+		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
+		return
+	}
+
+	if res["success"] == true {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("▶️ User `%s` berhasil *DIAKTIFKAN KEMBALI*.", username))
+		msg.ParseMode = "Markdown"
+		deleteLastMessage(bot, chatID)
+		bot.Send(msg)
+		showMainMenu(bot, chatID)
+	} else {
+		errMsg := apiErrorText(res)
+		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal unsuspend: %s", errMsg))
 		showMainMenu(bot, chatID)
 	}
 }
 
 func renewUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, limitIP int, limitQuota int) {
-	res, err := apiCall("POST", "/user/renew", map[string]interface{}{
-		"password":    username,
-		"days":        days,
+	res, err := apiCallV2("PATCH", "/users/"+url.PathEscape(username), map[string]interface{}{
+		"extend":      fmt.Sprintf("%dd", days),
 		"limit_ip":    limitIP,
 		"limit_quota": limitQuota,
 	})
@@ -1359,22 +2663,11 @@ func renewUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, li
 		}
 
 		ipInfo, _ := getIpInfo()
-
-		domain := "Unknown"
-		if d, ok := data["domain"].(string); ok && d != "" {
-			domain = d
-		} else {
-			if infoRes, err := apiCall("GET", "/info", nil); err == nil && infoRes["success"] == true {
-				if infoData, ok := infoRes["data"].(map[string]interface{}); ok {
-					if d, ok := infoData["domain"].(string); ok {
-						domain = d
-					}
-				}
-			}
-		}
+		domain := serverDomain()
 
 		msg := fmt.Sprintf("✅ *BERHASIL DIPERPANJANG* (%d Hari)\n"+
 			"━━━━━━━━━━━━━━━━━━━━━━━━━\n"+
+			"👤 *Username*: `%s`\n"+
 			"🔑 *Password*: `%s`\n"+
 			"🌐 *Domain*: `%s`\n"+
 			"🗓️ *Expired Baru*: `%s`\n"+
@@ -1383,7 +2676,7 @@ func renewUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, li
 			"📍 *Lokasi Server*: `%s`\n"+
 			"📡 *ISP Server*: `%s`\n"+
 			"━━━━━━━━━━━━━━━━━━━━━━━━━",
-			days, data["password"], domain, data["expired"], limitIP, limitQuota, ipInfo.City, ipInfo.Isp)
+			days, data["username"], data["password"], domain, formatExpiry(fmt.Sprint(data["expired"])), limitIP, limitQuota, ipInfo.City, ipInfo.Isp)
 
 		reply := tgbotapi.NewMessage(chatID, msg)
 		reply.ParseMode = "Markdown"
@@ -1391,55 +2684,61 @@ func renewUser(bot *tgbotapi.BotAPI, chatID int64, username string, days int, li
 		bot.Send(reply)
 		showMainMenu(bot, chatID)
 	} else {
-		errMsg, ok := res["message"].(string)
-		if !ok {
-			errMsg = "Pesan error tidak diketahui dari API."
-		}
+		errMsg := apiErrorText(res)
 		sendMessage(bot, chatID, fmt.Sprintf("❌ Gagal memperpanjang: %s", errMsg))
 		showMainMenu(bot, chatID)
 	}
 }
 
-func listUsers(bot *tgbotapi.BotAPI, chatID int64) {
-	res, err := apiCall("GET", "/users", nil)
+func listUsers(bot *tgbotapi.BotAPI, chatID int64, page int) {
+	perPage := 20
+	users, total, page, err := loadUserPage(chatID, "list", page, perPage, nil)
 	if err != nil {
 		sendMessage(bot, chatID, "❌ Error API: "+err.Error())
 		return
 	}
 
-	if res["success"] == true {
-		users, ok := res["data"].([]interface{})
-		if !ok {
-			sendMessage(bot, chatID, "❌ Format data user salah.")
-			return
-		}
+	if total == 0 {
+		sendMessage(bot, chatID, "📂 Tidak ada user saat ini.")
+		showMainMenu(bot, chatID)
+		return
+	}
 
-		if len(users) == 0 {
-			sendMessage(bot, chatID, "📂 Tidak ada user saat ini.")
-			showMainMenu(bot, chatID)
-			return
+	totalPages := (total + perPage - 1) / perPage
+	msg := fmt.Sprintf("📋 *DAFTAR AKUN ZIVPN* (Total: %d)\nHalaman %d/%d\n\n", total, page, totalPages)
+	for i, user := range users {
+		statusIcon := "🟢"
+		if user.Status == "Expired" {
+			statusIcon = "🔴"
+		} else if user.Status == "Suspended" {
+			statusIcon = "⏸️"
 		}
-
-		msg := fmt.Sprintf("📋 *DAFTAR AKUN ZIVPN* (Total: %d)\n\n", len(users))
-		for i, u := range users {
-			user, ok := u.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			statusIcon := "🟢"
-			if user["status"] == "Expired" {
-				statusIcon = "🔴"
-			}
-			msg += fmt.Sprintf("%d. %s `%s`\n    _Kadaluarsa: %s_\n", i+1, statusIcon, user["password"], user["expired"])
+		msg += fmt.Sprintf("%d. %s `%s` (pass: `%s`)\n    _Kadaluarsa: %s_\n    _Limit: %d IP / %d GB_\n    _Pemakaian: %.2f GB_\n", (page-1)*perPage+i+1, statusIcon, user.Username, user.Password, formatExpiry(user.Expired), user.LimitIP, user.LimitQuota, float64(user.UsageBytes)/(1024*1024*1024))
+		if user.Reason != "" {
+			msg += fmt.Sprintf("    _Suspend: %s_\n", user.Reason)
+		}
+		if user.Owner != "" {
+			msg += fmt.Sprintf("    _Reseller: %s_\n", user.Owner)
 		}
+	}
 
-		reply := tgbotapi.NewMessage(chatID, msg)
-		reply.ParseMode = "Markdown"
-		sendAndTrack(bot, reply)
-	} else {
-		sendMessage(bot, chatID, "❌ Gagal mengambil data daftar akun.")
+	var navRow []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("⬅️ Prev", fmt.Sprintf("page_list:%d", page-1)))
 	}
+	if page < totalPages {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("Next ➡️", fmt.Sprintf("page_list:%d", page+1)))
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	if len(navRow) > 0 {
+		rows = append(rows, navRow)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Menu", "cancel")))
+
+	reply := tgbotapi.NewMessage(chatID, msg)
+	reply.ParseMode = "Markdown"
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sendAndTrack(bot, reply)
 }
 
 func systemInfo(bot *tgbotapi.BotAPI, chatID int64) {